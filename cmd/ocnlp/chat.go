@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/winzerprince/oc-nlp/internal/app"
+	"github.com/winzerprince/oc-nlp/internal/chat"
+	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/llm"
+	"github.com/winzerprince/oc-nlp/internal/readline"
+)
+
+const chatHelp = `Type a question to ask it. Commands:
+  /sources     show the passages retrieved for the last answer
+  /prompt      show the prompt sent for the last answer
+  /k [n]       show or set how many passages are retrieved
+  /reset       start a new conversation
+  /save [file] save the conversation (and later turns) under the model;
+               with a file, also write a Markdown transcript to it
+  /help        show this help
+  /quit        leave (or Ctrl-D)
+Ctrl-C stops an answer that is being written.`
+
+// chatSession is the state of an `ocnlp chat` REPL.
+type chatSession struct {
+	store       *app.Store
+	model       string
+	embCfg      embeddings.Config
+	llmCfg      llm.Config
+	opt         chat.Options
+	showSources bool
+
+	conv  *app.Conversation
+	saved bool // whether conv is kept on disk after every turn
+	last  *chat.Result
+}
+
+func runChat(argv []string) {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	data := fs.String("data", ".ocnlp", "data directory")
+	host := fs.String("host", "http://localhost:11434", "Ollama host")
+	model := fs.String("model", "nomic-embed-text", "embedding model")
+	llmModel := fs.String("llm-model", llm.DefaultConfig().Model, "chat model")
+	topK := fs.Int("k", 4, "number of passages to retrieve")
+	showSources := fs.Bool("show-sources", false, "print the retrieved passages and scores before each answer")
+	convID := fs.String("conversation", "", "resume this saved conversation (see `ocnlp conversations list`)")
+	historyPath := fs.String("history", "", "input history file (default <data>/chat_history)")
+	_ = fs.Parse(argv)
+	args := fs.Args()
+	if len(args) < 1 {
+		log.Fatal("usage: ocnlp chat [flags] <model>")
+	}
+
+	s := &chatSession{
+		store:       app.NewStore(*data),
+		model:       args[0],
+		embCfg:      embeddings.DefaultConfig(),
+		llmCfg:      llm.DefaultConfig(),
+		opt:         chat.Options{SearchOptions: app.SearchOptions{TopK: *topK}},
+		showSources: *showSources,
+	}
+	s.embCfg.Host, s.embCfg.Model = *host, *model
+	s.llmCfg.Host, s.llmCfg.Model = *host, *llmModel
+	if _, err := s.store.GetModel(s.model); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	if *convID != "" {
+		s.conv, err = s.store.GetConversation(s.model, *convID)
+		s.saved = true
+	} else {
+		s.conv, err = s.store.NewConversation(s.model)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *historyPath == "" {
+		*historyPath = filepath.Join(*data, "chat_history")
+	}
+	rl := readline.New(os.Stdin, os.Stdout, *historyPath)
+	fmt.Printf("Chatting with %s using %s. Type /help for commands.\n", s.model, *llmModel)
+	if len(s.conv.Turns) > 0 {
+		fmt.Printf("Resumed conversation %s (%d turns).\n", s.conv.ID, len(s.conv.Turns))
+	}
+	warned := false
+	for {
+		line, err := rl.ReadLine(s.model + "> ")
+		if errors.Is(err, readline.ErrInterrupt) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := rl.HistoryErr(); err != nil && !warned {
+			fmt.Fprintln(os.Stderr, "history not saved:", err)
+			warned = true
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "/"):
+			if quit := s.command(line); quit {
+				return
+			}
+		default:
+			s.ask(line)
+		}
+	}
+}
+
+// command runs a slash command and reports whether the REPL should end.
+func (s *chatSession) command(line string) (quit bool) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/quit", "/exit":
+		return true
+	case "/help":
+		fmt.Println(chatHelp)
+	case "/sources":
+		if s.last == nil {
+			fmt.Println("(nothing asked yet)")
+			return false
+		}
+		printPassages(s.last.Retrieved)
+	case "/prompt":
+		if s.last == nil {
+			fmt.Println("(nothing asked yet)")
+			return false
+		}
+		fmt.Println(s.last.AssembledPrompt)
+	case "/k":
+		if arg == "" {
+			fmt.Println("k =", s.opt.TopK)
+			return false
+		}
+		k, err := strconv.Atoi(arg)
+		if err != nil || k < 1 {
+			fmt.Println("usage: /k <n>, with n at least 1")
+			return false
+		}
+		s.opt.TopK = k
+		fmt.Println("k =", k)
+	case "/reset":
+		conv, err := s.store.NewConversation(s.model)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return false
+		}
+		s.conv, s.saved, s.last = conv, false, nil
+		fmt.Println("Started a new conversation.")
+	case "/save":
+		if err := s.store.SaveConversation(s.conv); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return false
+		}
+		s.saved = true
+		fmt.Printf("Saved conversation %s; resume it with `ocnlp chat --conversation %s %s`.\n", s.conv.ID, s.conv.ID, s.model)
+		if arg != "" {
+			if err := os.WriteFile(arg, []byte(transcript(s.conv)), 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				return false
+			}
+			fmt.Println("Wrote transcript to", arg)
+		}
+	default:
+		fmt.Printf("unknown command %s; type /help\n", name)
+	}
+	return false
+}
+
+// ask answers question, streaming the answer to stdout. Ctrl-C stops it.
+func (s *chatSession) ask(question string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opt := s.opt
+	opt.History = s.conv.Turns
+	res, err := chat.AskStream(ctx, s.store, s.model, question, opt, s.embCfg, s.llmCfg,
+		func(res *chat.Result) error {
+			if res.Query != question {
+				fmt.Printf("(searching for: %s)\n", res.Query)
+			}
+			if s.showSources {
+				printPassages(res.Retrieved)
+			}
+			return nil
+		},
+		func(token string) error {
+			_, err := fmt.Print(token)
+			return err
+		},
+	)
+	fmt.Println()
+	if ctx.Err() != nil {
+		fmt.Println("(stopped)")
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return
+	}
+	printCitations(res.Citations)
+	s.last = res
+	s.conv.AddTurn(question, res.Query, res.Answer)
+	if s.saved {
+		if err := s.store.SaveConversation(s.conv); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+	}
+}
+
+// printPassages prints retrieved passages with their scores, shortened to a
+// few lines each.
+func printPassages(passages []chat.Retrieved) {
+	for i, p := range passages {
+		fmt.Printf("[%d] score=%.4f", i+1, p.Score)
+		if p.Reranked {
+			fmt.Printf(" rerank=%.2f", p.RerankScore)
+		}
+		if p.Heading != "" {
+			fmt.Printf(" · %s", p.Heading)
+		}
+		if p.Source != "" {
+			fmt.Printf(" · %s", citeLocation(p.Source, p.Page, p.ChunkIdx))
+		}
+		fmt.Println()
+		text := []rune(strings.Join(strings.Fields(p.Text), " "))
+		if len(text) > 300 {
+			text = append(text[:300], '…')
+		}
+		fmt.Printf("    %s\n", string(text))
+	}
+	fmt.Println()
+}
+
+// printCitations lists the sources an answer cites.
+func printCitations(cits []chat.Citation) {
+	if len(cits) == 0 {
+		return
+	}
+	fmt.Println("Sources:")
+	for _, c := range cits {
+		fmt.Printf("  [%d] %s (characters %d-%d)\n", c.N, citeLocation(c.Source, c.Page, c.ChunkIdx), c.Start, c.End)
+	}
+}
+
+func citeLocation(source string, page, chunkIdx int) string {
+	if page > 0 {
+		return fmt.Sprintf("%s, page %d, chunk %d", source, page, chunkIdx)
+	}
+	return fmt.Sprintf("%s, chunk %d", source, chunkIdx)
+}
+
+// transcript renders a conversation as Markdown.
+func transcript(c *app.Conversation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", c.Title)
+	fmt.Fprintf(&b, "Model `%s`, conversation `%s`.\n\n", c.Model, c.ID)
+	for _, t := range c.Turns {
+		fmt.Fprintf(&b, "**Q:** %s\n\n", t.Question)
+		b.WriteString(t.Answer + "\n\n")
+	}
+	return b.String()
+}
@@ -6,17 +6,26 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/winzerprince/oc-nlp/internal/app"
+	"github.com/winzerprince/oc-nlp/internal/chat"
 	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
+	"github.com/winzerprince/oc-nlp/internal/llm"
 	"github.com/winzerprince/oc-nlp/internal/server"
+	"github.com/winzerprince/oc-nlp/internal/vector"
 )
 
 func main() {
 	log.SetFlags(0)
 
 	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: ocnlp <server|models|model|ingest|build|search>")
+		fmt.Fprintln(os.Stderr, "usage: ocnlp <server|models|model|ingest|remove|build|search|ask|chat|conversations>")
 		os.Exit(2)
 	}
 
@@ -47,7 +56,7 @@ func main() {
 			return
 		}
 		for _, m := range models {
-			fmt.Printf("%s\tchunks=%d\tembeddings=%d\tupdated=%s\n", m.Name, m.Stats.Chunks, m.Stats.Embeddings, m.UpdatedAt)
+			fmt.Printf("%s\tsources=%d\tchunks=%d\tembeddings=%d\tupdated=%s\n", m.Name, m.Stats.Sources, m.Stats.Chunks, m.Stats.Embeddings, m.UpdatedAt)
 		}
 
 	case "ingest":
@@ -55,6 +64,7 @@ func main() {
 		// The stdlib flag package does not support interspersed flags, so we manually extract known flags.
 		data := ".ocnlp"
 		path := ""
+		var opt app.IngestOptions
 		args := make([]string, 0, len(os.Args[2:]))
 		for i := 2; i < len(os.Args); i++ {
 			a := os.Args[i]
@@ -71,12 +81,27 @@ func main() {
 				}
 				path = os.Args[i+1]
 				i++
+			case "--code":
+				opt.Code = true
+			case "--template", "--fields":
+				if i+1 >= len(os.Args) {
+					log.Fatal(a + " requires a value")
+				}
+				if opt.Rows == nil {
+					opt.Rows = &ingest.RowOptions{}
+				}
+				if a == "--template" {
+					opt.Rows.Template = os.Args[i+1]
+				} else {
+					opt.Rows.Fields = splitList(os.Args[i+1])
+				}
+				i++
 			default:
 				args = append(args, a)
 			}
 		}
 		if len(args) < 1 {
-			log.Fatal("usage: ocnlp ingest <model> --path <file|dir> [--data .ocnlp]")
+			log.Fatal("usage: ocnlp ingest <model> --path <file|dir> [--code] [--template <text/template>] [--fields a,b] [--data .ocnlp]")
 		}
 		model := args[0]
 		// ignore any extra positional args (often introduced by shell completion)
@@ -88,10 +113,32 @@ func main() {
 		if _, err := store.GetModel(model); err != nil {
 			log.Fatal(err)
 		}
-		if err := store.IngestSources(model, path); err != nil {
+		rep, err := store.IngestSourcesWith(model, path, opt)
+		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println("ingested into model:", model)
+		fmt.Printf("ingested into model: %s (added=%d updated=%d unchanged=%d skipped=%d)\n",
+			model, rep.Added, rep.Updated, rep.Unchanged, rep.Skipped)
+
+	case "remove":
+		fs := flag.NewFlagSet("remove", flag.ExitOnError)
+		data := fs.String("data", ".ocnlp", "data directory")
+		path := fs.String("path", "", "file or directory whose sources are removed")
+		_ = fs.Parse(os.Args[2:])
+		args := fs.Args()
+		if len(args) < 1 || *path == "" {
+			log.Fatal("usage: ocnlp remove [--data .ocnlp] --path <file|dir> <model>")
+		}
+		model := args[0]
+		store := app.NewStore(*data)
+		if _, err := store.GetModel(model); err != nil {
+			log.Fatal(err)
+		}
+		n, err := store.RemoveSources(model, *path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("removed %d sources from model: %s (run build to update the index)\n", n, model)
 
 	case "model":
 		if len(os.Args) < 3 {
@@ -125,6 +172,22 @@ func main() {
 		data := fs.String("data", ".ocnlp", "data directory")
 		host := fs.String("host", "http://localhost:11434", "Ollama host")
 		model := fs.String("model", "nomic-embed-text", "embedding model")
+		full := fs.Bool("full", false, "discard the existing index and re-embed every chunk")
+		batchSize := fs.Int("batch-size", embeddings.DefaultConfig().BatchSize, "texts per embedding request")
+		concurrency := fs.Int("concurrency", embeddings.DefaultConfig().Concurrency, "embedding requests in flight")
+		retries := fs.Int("retries", embeddings.DefaultConfig().Retry.MaxAttempts, "attempts per embedding request")
+		timeout := fs.Duration("timeout", embeddings.DefaultConfig().Retry.Timeout, "timeout per embedding request")
+		chunkRunes := fs.Int("chunk-runes", 0, "target chunk size in runes (default: value in model.json, else 900)")
+		overlap := fs.Int("overlap", 0, "overlap between chunks in runes (default: value in model.json, else 180)")
+		minRunes := fs.Int("min", 0, "minimum chunk size in runes (default: value in model.json, else 120)")
+		strategy := fs.String("strategy", "", "chunking strategy: runes|structure|markdown (default: value in model.json, else runes)")
+		prependHeading := fs.Bool("prepend-heading", false, "embed markdown chunks with their heading breadcrumb prepended")
+		backend := fs.String("index", "", "index backend: flat|hnsw|ivf (default: value in model.json, else flat)")
+		hnswM := fs.Int("hnsw-m", 0, "HNSW links per node (default 16)")
+		efConstruction := fs.Int("ef-construction", 0, "HNSW candidate list size while building (default 200)")
+		efSearch := fs.Int("ef-search", 0, "HNSW candidate list size while searching (default 64)")
+		nlist := fs.Int("nlist", 0, "IVF cluster count (default sqrt of the chunk count)")
+		nprobe := fs.Int("nprobe", 0, "IVF clusters scanned per search (default 8)")
 		_ = fs.Parse(os.Args[2:])
 		args := fs.Args()
 		if len(args) < 1 {
@@ -133,21 +196,81 @@ func main() {
 		modelName := args[0]
 
 		store := app.NewStore(*data)
-		if _, err := store.GetModel(modelName); err != nil {
+		meta, err := store.GetModel(modelName)
+		if err != nil {
 			log.Fatal(err)
 		}
 
+		opt := app.BuildOptions{Full: *full}
+		// only override the persisted chunking for flags given explicitly
+		fs.Visit(func(f *flag.Flag) {
+			if opt.Chunking == nil && (f.Name == "chunk-runes" || f.Name == "overlap" || f.Name == "min" || f.Name == "strategy" || f.Name == "prepend-heading") {
+				c := meta.ChunkOptions()
+				opt.Chunking = &c
+			}
+			switch f.Name {
+			case "chunk-runes":
+				opt.Chunking.TargetRunes = *chunkRunes
+			case "overlap":
+				opt.Chunking.OverlapRunes = *overlap
+			case "min":
+				opt.Chunking.MinRunes = *minRunes
+			case "strategy":
+				opt.Chunking.Strategy = *strategy
+			case "prepend-heading":
+				opt.Chunking.PrependHeading = *prependHeading
+			}
+		})
+		// likewise for the index backend
+		fs.Visit(func(f *flag.Flag) {
+			if opt.Index == nil && (f.Name == "index" || f.Name == "hnsw-m" || f.Name == "ef-construction" || f.Name == "ef-search" || f.Name == "nlist" || f.Name == "nprobe") {
+				c := meta.Index
+				opt.Index = &c
+			}
+			switch f.Name {
+			case "index":
+				opt.Index.Backend = *backend
+			case "hnsw-m":
+				opt.Index.HNSW.M = *hnswM
+			case "ef-construction":
+				opt.Index.HNSW.EfConstruction = *efConstruction
+			case "ef-search":
+				opt.Index.HNSW.EfSearch = *efSearch
+			case "nlist":
+				opt.Index.IVF.NList = *nlist
+			case "nprobe":
+				opt.Index.IVF.NProbe = *nprobe
+			}
+		})
+
 		cfg := embeddings.Config{
-			Host:  *host,
-			Model: *model,
+			Host:        *host,
+			Model:       *model,
+			BatchSize:   *batchSize,
+			Concurrency: *concurrency,
+			Retry:       embeddings.DefaultConfig().Retry,
+		}
+		cfg.Retry.MaxAttempts = *retries
+		cfg.Retry.Timeout = *timeout
+
+		opt.Progress = func(done, total int) {
+			fmt.Printf("  embedded %d/%d chunks\n", done, total)
 		}
 
 		fmt.Printf("Building index for model '%s' using %s on %s...\n", modelName, cfg.Model, cfg.Host)
-		ctx := context.Background()
-		if err := store.BuildIndex(ctx, modelName, cfg); err != nil {
+		// Ctrl-C cancels the build; embedded chunks are already checkpointed
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		rep, err := store.BuildIndex(ctx, modelName, cfg, opt)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Fatal("build interrupted; progress is checkpointed, re-run the same command to resume")
+			}
 			log.Fatal(err)
 		}
-		fmt.Println("Index built successfully")
+		fmt.Printf("Index built successfully (chunks=%d reused=%d resumed=%d embedded=%d removed=%d)\n",
+			rep.Chunks, rep.Reused, rep.Resumed, rep.Embedded, rep.Removed)
+		fmt.Printf("Index backend: %s dim=%d%s\n", rep.Index.Backend, rep.Index.Dimension, formatParams(rep.Index.Params))
 
 	case "search":
 		fs := flag.NewFlagSet("search", flag.ExitOnError)
@@ -156,6 +279,15 @@ func main() {
 		model := fs.String("model", "nomic-embed-text", "embedding model")
 		topK := fs.Int("k", 5, "number of results to return")
 		query := fs.String("query", "", "search query")
+		retries := fs.Int("retries", embeddings.DefaultConfig().Retry.MaxAttempts, "attempts per embedding request")
+		timeout := fs.Duration("timeout", embeddings.DefaultConfig().Retry.Timeout, "timeout per embedding request")
+		filterExpr := fs.String("filter", "", "metadata filter, e.g. 'source~=/reports/ AND kind=pdf'")
+		mode := fs.String("mode", app.ModeVector, "retrieval: lexical|vector|hybrid")
+		fusion := fs.String("fusion", app.FusionRRF, "hybrid fusion: rrf|weighted")
+		alpha := fs.Float64("alpha", 0.5, "weight of the vector score in weighted fusion (0-1)")
+		lexicalOnly := fs.Bool("lexical", false, "keyword search only; works without Ollama (same as --mode lexical)")
+		mmr := fs.Bool("mmr", false, "diversify results with Maximal Marginal Relevance")
+		lambda := fs.Float64("lambda", 0.5, "MMR trade-off: 1 = most relevant, 0 = most diverse")
 		_ = fs.Parse(os.Args[2:])
 		args := fs.Args()
 		if len(args) < 1 {
@@ -166,6 +298,10 @@ func main() {
 		if *query == "" {
 			log.Fatal("missing --query")
 		}
+		filter, err := vector.ParseFilter(*filterExpr)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		store := app.NewStore(*data)
 		if _, err := store.GetModel(modelName); err != nil {
@@ -175,10 +311,23 @@ func main() {
 		cfg := embeddings.Config{
 			Host:  *host,
 			Model: *model,
+			Retry: embeddings.DefaultConfig().Retry,
 		}
+		cfg.Retry.MaxAttempts = *retries
+		cfg.Retry.Timeout = *timeout
 
 		ctx := context.Background()
-		results, err := store.SearchIndex(ctx, modelName, *query, *topK, cfg)
+		opt := app.SearchOptions{TopK: *topK, Filter: filter, Mode: *mode, Fusion: *fusion, Alpha: alpha}
+		if *lexicalOnly {
+			opt.Mode = app.ModeLexical
+		}
+		if *mmr {
+			opt.MMRLambda = lambda
+		}
+		opt.Fallback = func(err error) {
+			fmt.Fprintf(os.Stderr, "Ollama unreachable (%v); showing keyword search results\n", err)
+		}
+		results, err := store.Search(ctx, modelName, *query, opt, cfg)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -195,11 +344,160 @@ func main() {
 			if source, ok := r.Document.Metadata["source"]; ok {
 				fmt.Printf("Source: %v\n", source)
 			}
+			if heading, ok := r.Document.Metadata["heading"]; ok {
+				fmt.Printf("Section: %v\n", heading)
+			}
+			if symbol, ok := r.Document.Metadata["symbol"]; ok {
+				fmt.Printf("Symbol: %v (lines %v-%v)\n", symbol, r.Document.Metadata["startLine"], r.Document.Metadata["endLine"])
+			}
 			fmt.Println()
 		}
 
+	case "ask":
+		fs := flag.NewFlagSet("ask", flag.ExitOnError)
+		data := fs.String("data", ".ocnlp", "data directory")
+		host := fs.String("host", "http://localhost:11434", "Ollama host")
+		model := fs.String("model", "nomic-embed-text", "embedding model")
+		llmModel := fs.String("llm-model", llm.DefaultConfig().Model, "chat model")
+		topK := fs.Int("k", 4, "number of passages to retrieve")
+		query := fs.String("query", "", "question")
+		convID := fs.String("conversation", "", "continue this conversation (see `ocnlp conversations list`)")
+		_ = fs.Parse(os.Args[2:])
+		args := fs.Args()
+		if len(args) < 1 {
+			log.Fatal("missing model name")
+		}
+		modelName := args[0]
+		if *query == "" {
+			log.Fatal("missing --query")
+		}
+
+		store := app.NewStore(*data)
+		var conv *app.Conversation
+		var err error
+		if *convID != "" {
+			conv, err = store.GetConversation(modelName, *convID)
+		} else {
+			conv, err = store.NewConversation(modelName)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		embCfg := embeddings.DefaultConfig()
+		embCfg.Host, embCfg.Model = *host, *model
+		llmCfg := llm.DefaultConfig()
+		llmCfg.Host, llmCfg.Model = *host, *llmModel
+		opt := chat.Options{SearchOptions: app.SearchOptions{TopK: *topK}, History: conv.Turns}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		res, err := chat.AskStream(ctx, store, modelName, *query, opt, embCfg, llmCfg,
+			func(res *chat.Result) error {
+				if res.Query != *query {
+					fmt.Fprintf(os.Stderr, "searching for: %s\n", res.Query)
+				}
+				return nil
+			},
+			func(token string) error {
+				_, err := fmt.Print(token)
+				return err
+			},
+		)
+		fmt.Println()
+		if err != nil {
+			log.Fatal(err)
+		}
+		printCitations(res.Citations)
+		conv.AddTurn(*query, res.Query, res.Answer)
+		if err := store.SaveConversation(conv); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "conversation: %s (continue with --conversation %s)\n", conv.ID, conv.ID)
+
+	case "chat":
+		runChat(os.Args[2:])
+
+	case "conversations":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: ocnlp conversations <list|show|delete> <model> [id] [--data .ocnlp]")
+			os.Exit(2)
+		}
+		sub := os.Args[2]
+		fs := flag.NewFlagSet("conversations "+sub, flag.ExitOnError)
+		data := fs.String("data", ".ocnlp", "data directory")
+		_ = fs.Parse(os.Args[3:])
+		args := fs.Args()
+		if len(args) < 1 {
+			log.Fatal("missing model name")
+		}
+		store := app.NewStore(*data)
+		modelName := args[0]
+		if sub != "list" && len(args) < 2 {
+			log.Fatal("missing conversation id")
+		}
+		switch sub {
+		case "list":
+			convs, err := store.ListConversations(modelName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(convs) == 0 {
+				fmt.Println("(no conversations)")
+				return
+			}
+			for _, c := range convs {
+				fmt.Printf("%s\tturns=%d\tupdated=%s\t%s\n", c.ID, len(c.Turns), c.UpdatedAt.Format(time.DateTime), c.Title)
+			}
+		case "show":
+			c, err := store.GetConversation(modelName, args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, t := range c.Turns {
+				fmt.Printf("> %s\n", t.Question)
+				if t.Query != "" {
+					fmt.Printf("  (searched for: %s)\n", t.Query)
+				}
+				fmt.Printf("%s\n\n", t.Answer)
+			}
+		case "delete":
+			if err := store.DeleteConversation(modelName, args[1]); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("deleted conversation:", args[1])
+		default:
+			fmt.Fprintln(os.Stderr, "unknown conversations subcommand:", sub)
+			os.Exit(2)
+		}
+
 	default:
 		fmt.Fprintln(os.Stderr, "unknown command:", os.Args[1])
 		os.Exit(2)
 	}
 }
+
+// formatParams renders index parameters as " key=value" pairs in key order.
+func formatParams(params map[string]int) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%d", k, params[k])
+	}
+	return b.String()
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
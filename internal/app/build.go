@@ -0,0 +1,442 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/winzerprince/oc-nlp/internal/chunk"
+	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
+	"github.com/winzerprince/oc-nlp/internal/lexical"
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+// BuildOptions controls how BuildIndex treats an existing index.
+type BuildOptions struct {
+	// Full discards any existing index and re-embeds every chunk. Chunks saved
+	// in the checkpoint of an interrupted build are still reused.
+	Full bool
+	// Chunking overrides the chunk options stored in model.json. The options
+	// used are persisted so later builds chunk the same way.
+	Chunking *chunk.Options
+	// CheckpointEvery is the number of newly embedded chunks between
+	// checkpoints (default 256).
+	CheckpointEvery int
+	// Progress, if set, is called after each checkpoint with the number of
+	// chunks embedded so far and the number that needed embedding.
+	Progress func(done, total int)
+	// Index overrides the index backend stored in model.json. Like Chunking,
+	// the setting used is persisted.
+	Index *vector.Config
+}
+
+// BuildReport summarises the work done by BuildIndex.
+type BuildReport struct {
+	Chunks   int `json:"chunks"`
+	Reused   int `json:"reused"`
+	Resumed  int `json:"resumed"` // taken from an interrupted build's checkpoint
+	Embedded int `json:"embedded"`
+	Removed  int `json:"removed"`
+
+	Index vector.Stats `json:"index"`
+}
+
+// BuildIndex builds the vector index for a model using Ollama embeddings,
+// and a lexical (BM25) index over the same chunks.
+// Unless opt.Full is set, embeddings from the previous index are reused for
+// chunks whose text is unchanged: chunk IDs hash the source path and the
+// chunk's text, so editing part of a file only sends the chunks that changed
+// to Ollama. Vectors of removed sources are dropped.
+//
+// New embeddings are appended to a checkpoint file in the model directory as
+// they arrive. If the build fails or ctx is cancelled, the checkpoint is kept
+// and the next build resumes from it; it is removed once the index is saved.
+func (s *Store) BuildIndex(ctx context.Context, model string, cfg embeddings.Config, opt BuildOptions) (*BuildReport, error) {
+	// Get sources manifest
+	manifest, err := s.loadSourcesManifest(model)
+	if err != nil {
+		return nil, fmt.Errorf("load sources: %w", err)
+	}
+
+	if len(manifest.Sources) == 0 {
+		return nil, errors.New("no sources to index")
+	}
+
+	meta, err := s.GetModel(model)
+	if err != nil {
+		return nil, fmt.Errorf("get model: %w", err)
+	}
+
+	chunkOpt := meta.ChunkOptions()
+	if opt.Chunking != nil {
+		chunkOpt = *opt.Chunking
+	}
+	if err := chunkOpt.Validate(); err != nil {
+		return nil, err
+	}
+	indexCfg := meta.Index
+	if opt.Index != nil {
+		indexCfg = *opt.Index
+	}
+	if err := indexCfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Embeddings from a different embedding model are not comparable, so the
+	// previous index can only be reused when the model is the same as last
+	// time. It is updated in place unless the backend or graph shape changed,
+	// in which case its vectors are copied into a new store.
+	inPlace := sameLayout(meta.Index, indexCfg)
+	var old vector.Store
+	if !opt.Full && meta.EmbeddingModel == cfg.Model {
+		openCfg := meta.Index
+		if inPlace {
+			openCfg = indexCfg
+		}
+		if st, err := s.openIndex(model, openCfg); err == nil {
+			old = st
+			defer old.Close()
+		}
+	}
+	idx := old
+	if idx == nil || !inPlace {
+		if idx, err = vector.NewStore(indexCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	resumed, err := loadCheckpoint(s.checkpointPath(model), cfg.Model)
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+
+	// Create embeddings client
+	embClient, err := embeddings.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create embeddings client: %w", err)
+	}
+
+	// Chunk every source, reusing previous embeddings where possible
+	var pending []vector.Document // chunks that still need an embedding
+	var updated []vector.Document // reused chunks whose metadata changed
+	lex := lexical.New()
+	rep := &BuildReport{}
+	seen := map[string]bool{}
+	for _, src := range manifest.Sources {
+		// Read text
+		text, err := readSource(&src)
+		if err != nil {
+			continue
+		}
+
+		chunks := splitSource(src, text, chunkOpt)
+
+		for _, c := range chunks {
+			if seen[c.ID] {
+				continue
+			}
+			seen[c.ID] = true
+
+			doc := vector.Document{
+				ID:   c.ID,
+				Text: c.Text,
+				Metadata: vector.Metadata{
+					"source":      src.Path,
+					"sourceSha":   src.SHA256,
+					"kind":        src.Kind,
+					"chunkIdx":    c.Index,
+					"totalChunks": len(chunks),
+					"start":       c.Start,
+					"end":         c.End,
+				},
+			}
+			if c.Heading != "" {
+				doc.Metadata["heading"] = c.Heading
+			}
+			if page := src.Page(c.Start); page > 0 {
+				doc.Metadata["page"] = page
+			}
+			if c.Symbol != "" {
+				doc.Metadata["symbol"] = c.Symbol
+			}
+			if c.StartLine > 0 {
+				doc.Metadata["startLine"] = c.StartLine
+				doc.Metadata["endLine"] = c.EndLine
+			}
+			if rec := src.Record(c.Start); rec != nil {
+				doc.Metadata["row"] = rec.Row
+				for k, v := range rec.Meta {
+					if _, ok := doc.Metadata[k]; !ok {
+						doc.Metadata[k] = v
+					}
+				}
+			}
+			for k, v := range src.Meta {
+				if _, ok := doc.Metadata[k]; !ok {
+					doc.Metadata[k] = v
+				}
+			}
+			lex.Add(doc.ID, lexicalText(doc))
+			if prev, ok := idx.Get(c.ID); ok {
+				if !sameMetadata(prev.Metadata, doc.Metadata) {
+					doc.Embedding = prev.Embedding
+					updated = append(updated, doc)
+				}
+				rep.Reused++
+			} else if prev, ok := getDoc(old, c.ID); ok {
+				doc.Embedding = prev.Embedding
+				idx.Add(doc)
+				rep.Reused++
+			} else if embedding, ok := resumed[c.ID]; ok {
+				doc.Embedding = embedding
+				idx.Add(doc)
+				rep.Resumed++
+			} else {
+				pending = append(pending, doc)
+			}
+		}
+	}
+
+	// Drop chunks of removed or changed sources and replace those whose
+	// metadata changed, in a single Delete: each call rewrites the store
+	var stale []string
+	for _, id := range idx.IDs() {
+		if !seen[id] {
+			stale = append(stale, id)
+		}
+	}
+	drop := stale
+	for _, doc := range updated {
+		drop = append(drop, doc.ID)
+	}
+	idx.Delete(drop...)
+	for _, doc := range updated {
+		idx.Add(doc)
+	}
+	if old != nil && old != idx {
+		for _, id := range old.IDs() {
+			if !seen[id] {
+				rep.Removed++
+			}
+		}
+	} else {
+		rep.Removed = len(stale)
+	}
+
+	// Embed new chunks in batches, checkpointing after each round
+	if len(pending) > 0 {
+		every := opt.CheckpointEvery
+		if every <= 0 {
+			every = 256
+		}
+		cp, err := openCheckpoint(s.checkpointPath(model))
+		if err != nil {
+			return nil, fmt.Errorf("open checkpoint: %w", err)
+		}
+		defer cp.Close()
+
+		for start := 0; start < len(pending); start += every {
+			round := pending[start:min(start+every, len(pending))]
+			texts := make([]string, len(round))
+			for i, doc := range round {
+				texts[i] = doc.Text
+			}
+			embs, err := embClient.EmbedBatch(ctx, texts)
+			if err != nil {
+				return nil, fmt.Errorf("embed chunks (%d of %d done, progress saved): %w", start, len(pending), err)
+			}
+			for i := range round {
+				round[i].Embedding = embs[i]
+			}
+			if err := cp.append(cfg.Model, round); err != nil {
+				return nil, fmt.Errorf("write checkpoint: %w", err)
+			}
+			for _, doc := range round {
+				idx.Add(doc)
+			}
+			rep.Embedded += len(round)
+			if opt.Progress != nil {
+				opt.Progress(rep.Embedded, len(pending))
+			}
+		}
+		if err := cp.Close(); err != nil {
+			return nil, fmt.Errorf("close checkpoint: %w", err)
+		}
+	}
+
+	// Save index
+	idx.SetEmbeddingModel(cfg.Model)
+	if err := idx.Save(s.indexPath(model)); err != nil {
+		return nil, fmt.Errorf("save index: %w", err)
+	}
+	if err := lex.Save(lexical.Path(s.indexPath(model))); err != nil {
+		return nil, fmt.Errorf("save lexical index: %w", err)
+	}
+	if err := s.removeStaleIndexFiles(model, indexCfg.BackendName()); err != nil {
+		return nil, err
+	}
+	rep.Chunks = idx.Count()
+	rep.Index = idx.Stats()
+
+	if err := os.Remove(s.checkpointPath(model)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove checkpoint: %w", err)
+	}
+
+	// Update model stats
+	meta.EmbeddingModel = cfg.Model
+	meta.Chunking = &chunkOpt
+	meta.Index = indexCfg
+	meta.Stats.Chunks = rep.Chunks
+	meta.Stats.Embeddings = idx.Count()
+	meta.UpdatedAt = time.Now().UTC()
+	if err := s.saveModel(meta); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// sameLayout reports whether an index built with a can be updated in place
+// to match b: the backend must be the same and, for HNSW, the graph shape.
+// Search-time settings such as efSearch and nprobe may differ.
+func sameLayout(a, b vector.Config) bool {
+	if a.BackendName() != b.BackendName() {
+		return false
+	}
+	if a.BackendName() == vector.BackendHNSW {
+		ah, bh := a.HNSW, b.HNSW
+		return (ah.M == bh.M || bh.M == 0) && (ah.EfConstruction == bh.EfConstruction || bh.EfConstruction == 0)
+	}
+	return true
+}
+
+func getDoc(st vector.Store, id string) (vector.Document, bool) {
+	if st == nil {
+		return vector.Document{}, false
+	}
+	return st.Get(id)
+}
+
+// markdownKinds are the source kinds whose text marks headings the markdown
+// way: markdown files, and documents whose extractors write their headings
+// as "#" lines.
+var markdownKinds = map[string]bool{"markdown": true, "html": true, "docx": true, "odt": true, "epub": true}
+
+// splitSource chunks the text of a source: code along its declarations,
+// the records of structured data one by one, and other kinds with the
+// strategy in opt.
+func splitSource(src ingest.Source, text string, opt chunk.Options) []chunk.Chunk {
+	if src.Kind == "code" {
+		return chunk.SplitCode(text, src.Path, src.Meta["language"], opt)
+	}
+	if len(src.Records) > 0 {
+		// every record (row) is a document of its own
+		runes := []rune(text)
+		row := src
+		row.Records = nil
+		seen := map[string]bool{}
+		var out []chunk.Chunk
+		for _, rec := range src.Records {
+			start, end := min(rec.Start, len(runes)), min(rec.End, len(runes))
+			for _, c := range splitSource(row, string(runes[start:end]), opt) {
+				if opt.Dedupe && seen[c.ID] {
+					continue
+				}
+				seen[c.ID] = true
+				c.Index = len(out)
+				c.Start += start
+				c.End += start
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	if opt.Strategy == chunk.StrategyMarkdown && !markdownKinds[src.Kind] {
+		// markdown mode only applies to sources with markdown headings
+		opt.Strategy = chunk.StrategyStructure
+	}
+	return chunk.Split(text, src.Path, opt)
+}
+
+// sameMetadata compares metadata by its JSON form, since metadata read back
+// from disk holds float64 where freshly built metadata holds int.
+func sameMetadata(a, b vector.Metadata) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+func (s *Store) checkpointPath(model string) string {
+	return filepath.Join(s.modelDir(model), "build.checkpoint.jsonl")
+}
+
+// checkpointEntry is one line of a build checkpoint.
+type checkpointEntry struct {
+	Model     string    `json:"model"`
+	ID        string    `json:"id"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// loadCheckpoint returns the embeddings saved by an interrupted build with the
+// given embedding model. A truncated last line (from a crash mid-write) is
+// ignored.
+func loadCheckpoint(path, model string) (map[string][]float64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]float64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := map[string][]float64{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var e checkpointEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Model == model && len(e.Embedding) > 0 {
+			out[e.ID] = e.Embedding
+		}
+	}
+	return out, sc.Err()
+}
+
+type checkpoint struct {
+	f *os.File
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{f: f}, nil
+}
+
+// append writes the embeddings of docs and syncs them to disk.
+func (c *checkpoint) append(model string, docs []vector.Document) error {
+	w := bufio.NewWriter(c.f)
+	enc := json.NewEncoder(w)
+	for _, doc := range docs {
+		if err := enc.Encode(checkpointEntry{Model: model, ID: doc.ID, Embedding: doc.Embedding}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return c.f.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Turn is one question and its answer in a conversation.
+type Turn struct {
+	Question string    `json:"question"`
+	Query    string    `json:"query,omitempty"` // standalone search query, if the question was rewritten
+	Answer   string    `json:"answer"`
+	Time     time.Time `json:"time"`
+}
+
+// Conversation is a chat session with a model, stored as
+// models/<name>/conversations/<id>.json.
+type Conversation struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	Title     string    `json:"title"` // the first question, shortened
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Turns     []Turn    `json:"turns"`
+}
+
+// AddTurn appends a turn, titling the conversation after its first question.
+func (c *Conversation) AddTurn(question, query, answer string) {
+	now := time.Now().UTC()
+	if query == question {
+		query = ""
+	}
+	c.Turns = append(c.Turns, Turn{Question: question, Query: query, Answer: answer, Time: now})
+	if c.Title == "" {
+		c.Title = shorten(strings.Join(strings.Fields(question), " "), 80)
+	}
+	c.UpdatedAt = now
+}
+
+func shorten(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
+
+var reConversationID = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{6}$`)
+
+// conversationsDir returns the model's conversations directory. Model names
+// come from requests, so they are checked like in CreateModel.
+func (s *Store) conversationsDir(model string) (string, error) {
+	if !reName.MatchString(model) {
+		return "", fmt.Errorf("invalid model name %q", model)
+	}
+	return filepath.Join(s.modelDir(model), "conversations"), nil
+}
+
+func (s *Store) conversationPath(model, id string) (string, error) {
+	if !reConversationID.MatchString(id) {
+		return "", fmt.Errorf("invalid conversation id %q", id)
+	}
+	dir, err := s.conversationsDir(model)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// NewConversation starts a conversation with model. It is not written to
+// disk until SaveConversation.
+func (s *Store) NewConversation(model string) (*Conversation, error) {
+	if _, err := s.GetModel(model); err != nil {
+		return nil, err
+	}
+	var suffix [3]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	id := now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix[:])
+	return &Conversation{ID: id, Model: model, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+// SaveConversation writes c to the model's conversations directory.
+func (s *Store) SaveConversation(c *Conversation) error {
+	path, err := s.conversationPath(c.Model, c.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal conversation: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return fmt.Errorf("write conversation: %w", err)
+	}
+	return nil
+}
+
+// GetConversation reads a saved conversation. A missing one returns an error
+// wrapping os.ErrNotExist.
+func (s *Store) GetConversation(model, id string) (*Conversation, error) {
+	path, err := s.conversationPath(model, id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("conversation %s: %w", id, err)
+	}
+	var c Conversation
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("conversation %s: %w", id, err)
+	}
+	return &c, nil
+}
+
+// ListConversations returns the model's conversations, most recently updated
+// first.
+func (s *Store) ListConversations(model string) ([]Conversation, error) {
+	dir, err := s.conversationsDir(model)
+	if err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Conversation
+	for _, e := range ents {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		c, err := s.GetConversation(model, id)
+		if err != nil {
+			continue
+		}
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	return out, nil
+}
+
+// DeleteConversation removes a saved conversation.
+func (s *Store) DeleteConversation(model, id string) error {
+	path, err := s.conversationPath(model, id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("conversation %s: %w", id, err)
+	}
+	return nil
+}
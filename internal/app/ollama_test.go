@@ -0,0 +1,76 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/winzerprince/oc-nlp/internal/embeddings"
+)
+
+// fakeOllama serves /api/embed with deterministic letter-frequency vectors and
+// counts how many texts it was asked to embed. When limit is positive, requests
+// that would take the count past it fail with a permanent error.
+type fakeOllama struct {
+	srv   *httptest.Server
+	texts atomic.Int64
+	limit atomic.Int64
+}
+
+func newFakeOllama(t *testing.T) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Input any `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var inputs []string
+		switch in := req.Input.(type) {
+		case string:
+			inputs = []string{in}
+		case []any:
+			for _, v := range in {
+				s, _ := v.(string)
+				inputs = append(inputs, s)
+			}
+		}
+		if l := f.limit.Load(); l > 0 && f.texts.Load()+int64(len(inputs)) > l {
+			http.Error(w, `{"error":"rejected by test"}`, http.StatusBadRequest)
+			return
+		}
+		f.texts.Add(int64(len(inputs)))
+		out := make([][]float32, len(inputs))
+		for i, s := range inputs {
+			out[i] = letterVector(s)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"embeddings": out})
+	}))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeOllama) config() embeddings.Config {
+	return embeddings.Config{Host: f.srv.URL, Model: "fake-embed"}
+}
+
+func letterVector(s string) []float32 {
+	v := make([]float32, 27)
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' {
+			v[r-'a']++
+		}
+	}
+	v[26] = 1 // never a zero vector
+	return v
+}
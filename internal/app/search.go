@@ -0,0 +1,334 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/lexical"
+	"github.com/winzerprince/oc-nlp/internal/retry"
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+// Search modes selectable through SearchOptions.Mode.
+const (
+	ModeVector  = "vector"  // cosine similarity of embeddings (default)
+	ModeLexical = "lexical" // BM25 keyword ranking, no embeddings needed
+	ModeHybrid  = "hybrid"  // both, fused into one ranking
+)
+
+// Fusion methods for hybrid search.
+const (
+	FusionRRF      = "rrf"      // reciprocal rank fusion (default)
+	FusionWeighted = "weighted" // blend of min-max normalised scores
+)
+
+// rrfK damps the weight of top ranks in reciprocal rank fusion.
+const rrfK = 60
+
+// SearchOptions controls a search of a model's index.
+type SearchOptions struct {
+	TopK int
+	// Filter restricts the search to chunks whose metadata matches it.
+	Filter *vector.Filter
+	// Mode is ModeVector, ModeLexical or ModeHybrid (empty means vector).
+	Mode string
+	// Fusion is how hybrid search combines the two rankings (empty means rrf).
+	Fusion string
+	// Alpha is the weight of the vector score in weighted fusion, between 0
+	// (lexical only) and 1 (vector only). Nil means 0.5.
+	Alpha *float64
+	// Fallback, if set, is called when Ollama cannot be reached to embed the
+	// query and the search falls back to lexical mode.
+	Fallback func(err error)
+	// MMRLambda, if set, diversifies the results with Maximal Marginal
+	// Relevance (see vector.MMR): candidates are over-retrieved and re-ranked
+	// with this lambda, between 0 (most diverse) and 1 (most relevant).
+	MMRLambda *float64
+}
+
+func (o SearchOptions) validate() error {
+	switch o.Mode {
+	case "", ModeVector, ModeLexical, ModeHybrid:
+	default:
+		return fmt.Errorf("unknown search mode %q (want %s, %s or %s)", o.Mode, ModeVector, ModeLexical, ModeHybrid)
+	}
+	switch o.Fusion {
+	case "", FusionRRF, FusionWeighted:
+	default:
+		return fmt.Errorf("unknown fusion %q (want %s or %s)", o.Fusion, FusionRRF, FusionWeighted)
+	}
+	if o.Alpha != nil && (*o.Alpha < 0 || *o.Alpha > 1) {
+		return fmt.Errorf("alpha %v is outside [0, 1]", *o.Alpha)
+	}
+	if o.MMRLambda != nil && (*o.MMRLambda < 0 || *o.MMRLambda > 1) {
+		return fmt.Errorf("MMR lambda %v is outside [0, 1]", *o.MMRLambda)
+	}
+	return nil
+}
+
+// SearchIndex performs a semantic search on the model's index
+func (s *Store) SearchIndex(ctx context.Context, model string, query string, topK int, cfg embeddings.Config) ([]vector.SearchResult, error) {
+	return s.SearchIndexWith(ctx, model, query, SearchOptions{TopK: topK}, cfg)
+}
+
+// SearchIndexWith is SearchIndex with options such as a metadata filter, a
+// search mode or MMR diversification (SearchOptions.MMRLambda); see Search.
+func (s *Store) SearchIndexWith(ctx context.Context, model string, query string, opt SearchOptions, cfg embeddings.Config) ([]vector.SearchResult, error) {
+	return s.Search(ctx, model, query, opt, cfg)
+}
+
+// Search searches the model's index with options. Lexical search does not
+// call Ollama; vector and hybrid search embed the query with cfg and fall
+// back to lexical search when Ollama is unreachable.
+func (s *Store) Search(ctx context.Context, model string, query string, opt SearchOptions, cfg embeddings.Config) ([]vector.SearchResult, error) {
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	if opt.MMRLambda == nil {
+		return s.search(ctx, model, query, opt, cfg)
+	}
+
+	inner := opt
+	inner.TopK = max(4*opt.TopK, 20)
+	cosine := opt.Mode == "" || opt.Mode == ModeVector
+	inner.Fallback = func(err error) {
+		cosine = false
+		if opt.Fallback != nil {
+			opt.Fallback(err)
+		}
+	}
+	candidates, err := s.search(ctx, model, query, inner, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cosine {
+		return vector.MMR(candidates, opt.TopK, *opt.MMRLambda), nil
+	}
+
+	// BM25 and fused scores are on another scale than the cosine similarity
+	// MMR weighs them against, so rank on scores relative to the best one.
+	scores := make(map[string]float64, len(candidates))
+	top := 0.0
+	for _, c := range candidates {
+		scores[c.Document.ID] = c.Score
+		top = max(top, c.Score)
+	}
+	for i := range candidates {
+		if top > 0 {
+			candidates[i].Score /= top
+		}
+	}
+	results := vector.MMR(candidates, opt.TopK, *opt.MMRLambda)
+	for i := range results {
+		results[i].Score = scores[results[i].Document.ID]
+	}
+	return results, nil
+}
+
+// normalizeScores min-max scales the scores of results to [0, 1] in place.
+func normalizeScores(results []vector.SearchResult) {
+	if len(results) == 0 {
+		return
+	}
+	lo, hi := results[0].Score, results[0].Score
+	for _, r := range results {
+		lo, hi = min(lo, r.Score), max(hi, r.Score)
+	}
+	for i := range results {
+		if hi > lo {
+			results[i].Score = (results[i].Score - lo) / (hi - lo)
+		} else {
+			results[i].Score = 1
+		}
+	}
+}
+
+func (s *Store) search(ctx context.Context, model string, query string, opt SearchOptions, cfg embeddings.Config) ([]vector.SearchResult, error) {
+	meta, err := s.GetModel(model)
+	if err != nil {
+		return nil, fmt.Errorf("get model: %w", err)
+	}
+
+	if opt.Mode == ModeLexical {
+		// every backend stores its documents in the flat index files
+		if err := s.migrateIndex(model); err != nil {
+			return nil, fmt.Errorf("migrate index: %w", err)
+		}
+		docs, err := vector.Open(s.indexPath(model))
+		if err != nil {
+			return nil, fmt.Errorf("load index: %w", err)
+		}
+		defer docs.Close()
+		return s.searchLexical(model, docs, query, opt.TopK, opt.Filter)
+	}
+
+	// Load index
+	idx, err := s.openIndex(model, meta.Index)
+	if err != nil {
+		return nil, fmt.Errorf("load index: %w", err)
+	}
+	defer idx.Close()
+	if built := idx.Stats().EmbeddingModel; built != "" && cfg.Model != "" && built != cfg.Model {
+		return nil, fmt.Errorf("index was built with embedding model %q, not %q", built, cfg.Model)
+	}
+
+	// Create embeddings client
+	embClient, err := embeddings.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create embeddings client: %w", err)
+	}
+
+	// Generate query embedding, falling back to keyword search when Ollama
+	// is down
+	queryEmb, err := embClient.Embed(ctx, query)
+	if err != nil && ctx.Err() == nil && retry.Unreachable(err) {
+		if opt.Fallback != nil {
+			opt.Fallback(err)
+		}
+		return s.searchLexical(model, idx, query, opt.TopK, opt.Filter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+
+	if opt.Mode != ModeHybrid {
+		results, err := idx.SearchFilter(queryEmb, opt.TopK, opt.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("search: %w", err)
+		}
+		return results, nil
+	}
+
+	// Hybrid: rank a deeper candidate list with each method, then fuse
+	depth := max(4*opt.TopK, 50)
+	semantic, err := idx.SearchFilter(queryEmb, depth, opt.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	keyword, err := s.searchLexical(model, idx, query, depth, opt.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Fusion == FusionWeighted {
+		alpha := 0.5
+		if opt.Alpha != nil {
+			alpha = *opt.Alpha
+		}
+		return blendScores(semantic, keyword, alpha, opt.TopK), nil
+	}
+	return fuseRRF(opt.TopK, semantic, keyword), nil
+}
+
+// docLookup is the part of a vector store lexical search needs.
+type docLookup interface {
+	IDs() []string
+	Get(id string) (vector.Document, bool)
+}
+
+// searchLexical ranks chunks by BM25 and resolves them to documents.
+func (s *Store) searchLexical(model string, docs docLookup, query string, topK int, filter *vector.Filter) ([]vector.SearchResult, error) {
+	lex, err := s.openLexical(model, docs)
+	if err != nil {
+		return nil, fmt.Errorf("load lexical index: %w", err)
+	}
+	found := map[string]vector.Document{}
+	hits := lex.Search(query, topK, func(id string) bool {
+		doc, ok := docs.Get(id)
+		if !ok || !filter.Match(doc.Metadata) {
+			return false
+		}
+		found[id] = doc
+		return true
+	})
+	results := make([]vector.SearchResult, len(hits))
+	for i, h := range hits {
+		results[i] = vector.SearchResult{Document: found[h.ID], Score: h.Score}
+	}
+	return results, nil
+}
+
+// openLexical loads the model's lexical index, building it from the stored
+// chunks when the model was indexed before lexical search existed.
+func (s *Store) openLexical(model string, docs docLookup) (*lexical.Index, error) {
+	lex, err := lexical.Load(lexical.Path(s.indexPath(model)))
+	if err == nil {
+		return lex, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, lexical.ErrFormat) {
+		return nil, err
+	}
+	lex = lexical.New()
+	for _, id := range docs.IDs() {
+		if doc, ok := docs.Get(id); ok {
+			lex.Add(doc.ID, lexicalText(doc))
+		}
+	}
+	return lex, nil
+}
+
+// lexicalText is the text a chunk is keyword-indexed under: its text and its
+// heading breadcrumb.
+func lexicalText(doc vector.Document) string {
+	if heading, _ := doc.Metadata["heading"].(string); heading != "" {
+		return heading + "\n" + doc.Text
+	}
+	return doc.Text
+}
+
+// fuseRRF merges rankings by reciprocal rank fusion: each document scores
+// the sum of 1/(rrfK+rank) over the rankings it appears in.
+func fuseRRF(topK int, rankings ...[]vector.SearchResult) []vector.SearchResult {
+	scores := map[string]float64{}
+	docs := map[string]vector.Document{}
+	for _, ranking := range rankings {
+		for rank, r := range ranking {
+			scores[r.Document.ID] += 1 / float64(rrfK+rank+1)
+			if _, ok := docs[r.Document.ID]; !ok {
+				docs[r.Document.ID] = r.Document
+			}
+		}
+	}
+	return rankFused(scores, docs, topK)
+}
+
+// blendScores min-max normalises each ranking's scores to [0, 1] and ranks
+// documents by alpha*semantic + (1-alpha)*keyword, counting a document that
+// is missing from a ranking as 0 there.
+func blendScores(semantic, keyword []vector.SearchResult, alpha float64, topK int) []vector.SearchResult {
+	scores := map[string]float64{}
+	docs := map[string]vector.Document{}
+	for _, part := range []struct {
+		ranking []vector.SearchResult
+		weight  float64
+	}{{semantic, alpha}, {keyword, 1 - alpha}} {
+		normalizeScores(part.ranking)
+		for _, r := range part.ranking {
+			scores[r.Document.ID] += part.weight * r.Score
+			if _, ok := docs[r.Document.ID]; !ok {
+				docs[r.Document.ID] = r.Document
+			}
+		}
+	}
+	return rankFused(scores, docs, topK)
+}
+
+func rankFused(scores map[string]float64, docs map[string]vector.Document, topK int) []vector.SearchResult {
+	results := make([]vector.SearchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, vector.SearchResult{Document: docs[id], Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Document.ID < results[j].Document.ID
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results
+}
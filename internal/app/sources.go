@@ -0,0 +1,42 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/winzerprince/oc-nlp/internal/chunk"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
+)
+
+// SourceText is a source's extracted text and the chunks it is split into.
+type SourceText struct {
+	Source ingest.Source
+	Text   string
+	Chunks []chunk.Chunk
+}
+
+// GetSourceText reads the text extracted from the model's source with the
+// given hash and splits it the way BuildIndex does with the model's chunk
+// options, so chunk offsets match the index. An unknown hash returns an
+// error wrapping os.ErrNotExist.
+func (s *Store) GetSourceText(model, sha string) (*SourceText, error) {
+	meta, err := s.GetModel(model)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := s.loadSourcesManifest(model)
+	if err != nil {
+		return nil, fmt.Errorf("load sources: %w", err)
+	}
+	for _, src := range manifest.Sources {
+		if src.SHA256 != sha {
+			continue
+		}
+		text, err := readSource(&src)
+		if err != nil {
+			return nil, err
+		}
+		return &SourceText{Source: src, Text: text, Chunks: splitSource(src, text, meta.ChunkOptions())}, nil
+	}
+	return nil, fmt.Errorf("source %s: %w", sha, os.ErrNotExist)
+}
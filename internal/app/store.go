@@ -1,7 +1,6 @@
 package app
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,7 +11,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/chunk"
 	"github.com/winzerprince/oc-nlp/internal/ingest"
 	"github.com/winzerprince/oc-nlp/internal/vector"
 )
@@ -28,15 +27,28 @@ func NewStore(dataDir string) *Store {
 var reName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,63}$`)
 
 type ModelStats struct {
+	Sources    int `json:"sources"`
 	Chunks     int `json:"chunks"`
 	Embeddings int `json:"embeddings"`
 }
 
 type ModelMeta struct {
-	Name      string     `json:"name"`
-	CreatedAt time.Time  `json:"createdAt"`
-	UpdatedAt time.Time  `json:"updatedAt"`
-	Stats     ModelStats `json:"stats"`
+	Name           string             `json:"name"`
+	CreatedAt      time.Time          `json:"createdAt"`
+	UpdatedAt      time.Time          `json:"updatedAt"`
+	EmbeddingModel string             `json:"embeddingModel,omitempty"` // model used for the current index
+	Chunking       *chunk.Options     `json:"chunking,omitempty"`       // options used for the current index
+	Rows           *ingest.RowOptions `json:"rows,omitempty"`           // row options for structured data sources
+	Index          vector.Config      `json:"index,omitzero"`           // backend of the current index
+	Stats          ModelStats         `json:"stats"`
+}
+
+// ChunkOptions returns the chunk options the model's index is built with.
+func (m *ModelMeta) ChunkOptions() chunk.Options {
+	if m.Chunking != nil {
+		return *m.Chunking
+	}
+	return chunk.DefaultOptions()
 }
 
 func (s *Store) modelsDir() string {
@@ -124,186 +136,357 @@ func (s *Store) manifestPath(model string) string {
 	return filepath.Join(s.modelDir(model), "sources.json")
 }
 
-func (s *Store) IngestSources(model, path string) error {
+// IngestReport summarises what a single IngestSources call changed.
+type IngestReport struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"` // unsupported, unreadable or duplicate content
+}
+
+// IngestOptions controls which files IngestSourcesWith accepts.
+type IngestOptions struct {
+	// Code ingests source code files as well as documents. They are chunked
+	// along their declarations when the index is built.
+	Code bool
+	// Rows overrides the row options stored in model.json, which say how
+	// CSV, JSON and JSONL rows become text and metadata. The options used
+	// are persisted so later ingests treat rows the same way.
+	Rows *ingest.RowOptions
+}
+
+// IngestSources extracts text from every file under path and merges it into
+// the model's manifest. Sources are keyed by content hash: a path whose content
+// is unchanged is skipped, a path whose content changed replaces its previous
+// entry (or is dropped if another path already has the new content), and
+// sources ingested by earlier calls are kept.
+func (s *Store) IngestSources(model, path string) (*IngestReport, error) {
+	return s.IngestSourcesWith(model, path, IngestOptions{})
+}
+
+// IngestSourcesWith is IngestSources with options.
+func (s *Store) IngestSourcesWith(model, path string, opt IngestOptions) (*IngestReport, error) {
+	extractOpt := ingest.ExtractOptions{Code: opt.Code}
+	if opt.Rows != nil {
+		if err := opt.Rows.Validate(); err != nil {
+			return nil, err
+		}
+		extractOpt.Rows = *opt.Rows
+	} else if meta, err := s.GetModel(model); err == nil && meta.Rows != nil {
+		extractOpt.Rows = *meta.Rows
+	}
 	paths, err := ingest.WalkPaths(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	manifest, err := s.loadSourcesManifest(model)
+	if errors.Is(err, os.ErrNotExist) {
+		manifest = &SourcesManifest{Model: model}
+	} else if err != nil {
+		return nil, fmt.Errorf("load sources: %w", err)
 	}
-	out := SourcesManifest{Model: model}
+	manifest.Model = model
+
+	bySHA := make(map[string]int, len(manifest.Sources))
+	byPath := make(map[string]int, len(manifest.Sources))
+	for i, src := range manifest.Sources {
+		bySHA[src.SHA256] = i
+		byPath[src.Path] = i
+	}
+
+	rep := &IngestReport{}
+	dropped := map[int]bool{} // entries whose content moved to another path
+	now := time.Now().UTC()
 	for _, p := range paths {
-		kind, text, sum, err := ingest.ExtractText(p)
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		ext, err := ingest.ExtractWith(p, extractOpt)
 		if err != nil {
+			rep.Skipped++
+			continue
+		}
+
+		kind, sum := ext.Kind, ext.SHA256
+		i, known := byPath[p]
+		if known && manifest.Sources[i].SHA256 == sum {
+			// extractors may classify files and find pages or metadata
+			// differently over time
+			manifest.Sources[i].Kind = kind
+			manifest.Sources[i].Pages = ext.Pages
+			manifest.Sources[i].Meta = ext.Meta
+			manifest.Sources[i].RecordsPath, err = s.writeSourceRecords(model, sum, ext.Records)
+			if err != nil {
+				return nil, err
+			}
+			rep.Unchanged++
+			continue
+		}
+		if _, dup := bySHA[sum]; dup {
+			// identical content is already ingested under another path; a
+			// tracked path that now has it no longer holds its old text
+			if known {
+				old := manifest.Sources[i]
+				delete(bySHA, old.SHA256)
+				delete(byPath, p)
+				removeSourceFiles(old)
+				dropped[i] = true
+				rep.Updated++
+				continue
+			}
+			rep.Skipped++
 			continue
 		}
-		dest := filepath.Join(s.sourcesDir(model), sum+".txt")
-		if err := os.MkdirAll(s.sourcesDir(model), 0o755); err != nil {
-			return err
+
+		dest, err := s.writeSourceText(model, sum, ext.Text)
+		if err != nil {
+			return nil, err
+		}
+		records, err := s.writeSourceRecords(model, sum, ext.Records)
+		if err != nil {
+			return nil, err
 		}
-		if err := os.WriteFile(dest, []byte(text), 0o644); err != nil {
-			return err
+		if known {
+			old := manifest.Sources[i]
+			delete(bySHA, old.SHA256)
+			removeSourceFiles(old)
+			manifest.Sources[i].Kind = kind
+			manifest.Sources[i].SHA256 = sum
+			manifest.Sources[i].TextPath = dest
+			manifest.Sources[i].Pages = ext.Pages
+			manifest.Sources[i].Meta = ext.Meta
+			manifest.Sources[i].RecordsPath = records
+			manifest.Sources[i].UpdatedAt = now
+			bySHA[sum] = i
+			rep.Updated++
+			continue
 		}
-		out.Sources = append(out.Sources, ingest.Source{Path: p, Kind: kind, SHA256: sum, TextPath: dest})
+		manifest.Sources = append(manifest.Sources, ingest.Source{
+			Path:        p,
+			Kind:        kind,
+			SHA256:      sum,
+			TextPath:    dest,
+			IngestedAt:  now,
+			UpdatedAt:   now,
+			Pages:       ext.Pages,
+			Meta:        ext.Meta,
+			RecordsPath: records,
+		})
+		bySHA[sum] = len(manifest.Sources) - 1
+		byPath[p] = len(manifest.Sources) - 1
+		rep.Added++
 	}
-	b, _ := json.MarshalIndent(out, "", "  ")
-	if err := os.WriteFile(s.manifestPath(model), b, 0o644); err != nil {
-		return err
+
+	if len(dropped) > 0 {
+		kept := manifest.Sources[:0]
+		for i, src := range manifest.Sources {
+			if !dropped[i] {
+				kept = append(kept, src)
+			}
+		}
+		manifest.Sources = kept
+	}
+	sort.Slice(manifest.Sources, func(i, j int) bool { return manifest.Sources[i].Path < manifest.Sources[j].Path })
+	if err := s.saveSourcesManifest(manifest); err != nil {
+		return nil, err
 	}
-	// update stats
+
 	meta, err := s.GetModel(model)
 	if err == nil {
-		meta.Stats.Chunks = meta.Stats.Chunks // unchanged; chunking comes later
-		meta.UpdatedAt = time.Now().UTC()
-		mb, _ := json.MarshalIndent(meta, "", "  ")
-		_ = os.WriteFile(s.metaPath(model), mb, 0o644)
+		meta.Stats.Sources = len(manifest.Sources)
+		meta.UpdatedAt = now
+		if opt.Rows != nil {
+			meta.Rows = opt.Rows
+		}
+		if err := s.saveModel(meta); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	return rep, nil
 }
 
-func (s *Store) indexPath(model string) string {
-	return filepath.Join(s.modelDir(model), "index.json")
-}
-
-// simpleChunk performs simple chunking of text into fixed-size overlapping chunks
-func simpleChunk(text string, chunkSize, overlap int) []string {
-	if chunkSize <= 0 {
-		return []string{}
+// RemoveSources drops the model's sources at path, a file or every file
+// under a directory, and deletes their extracted text. The path need not
+// exist any more. Their chunks leave the index on the next build. It returns
+// the number of sources removed.
+func (s *Store) RemoveSources(model, path string) (int, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
 	}
-
-	words := strings.Fields(text)
-	if len(words) == 0 {
-		return []string{}
+	manifest, err := s.loadSourcesManifest(model)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("load sources: %w", err)
 	}
+	manifest.Model = model
 
-	chunks := make([]string, 0)
-	step := chunkSize - overlap
-	if step <= 0 {
-		step = chunkSize
+	kept := manifest.Sources[:0]
+	removed := 0
+	for _, src := range manifest.Sources {
+		if src.Path != path && !strings.HasPrefix(src.Path, path+string(filepath.Separator)) {
+			kept = append(kept, src)
+			continue
+		}
+		removeSourceFiles(src)
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	manifest.Sources = kept
+	if err := s.saveSourcesManifest(manifest); err != nil {
+		return 0, err
 	}
 
-	for i := 0; i < len(words); i += step {
-		end := i + chunkSize
-		if end > len(words) {
-			end = len(words)
-		}
-		chunk := strings.Join(words[i:end], " ")
-		chunks = append(chunks, chunk)
-		if end >= len(words) {
-			break
+	meta, err := s.GetModel(model)
+	if err == nil {
+		meta.Stats.Sources = len(manifest.Sources)
+		meta.UpdatedAt = time.Now().UTC()
+		if err := s.saveModel(meta); err != nil {
+			return 0, err
 		}
 	}
+	return removed, nil
+}
 
-	return chunks
+func (s *Store) writeSourceText(model, sum, text string) (string, error) {
+	if err := os.MkdirAll(s.sourcesDir(model), 0o755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(s.sourcesDir(model), sum+".txt")
+	if err := os.WriteFile(dest, []byte(text), 0o644); err != nil {
+		return "", err
+	}
+	return dest, nil
 }
 
-// BuildIndex builds the vector index for a model using Ollama embeddings
-func (s *Store) BuildIndex(ctx context.Context, model string, cfg embeddings.Config) error {
-	// Get sources manifest
-	manifest, err := s.loadSourcesManifest(model)
+// writeSourceRecords writes the records of a source's text to their own
+// file beside it, keeping large tables out of sources.json, and returns its
+// path. Sources without records have no file.
+func (s *Store) writeSourceRecords(model, sum string, records []ingest.Record) (string, error) {
+	dest := filepath.Join(s.sourcesDir(model), sum+".records.json")
+	if len(records) == 0 {
+		_ = os.Remove(dest)
+		return "", nil
+	}
+	b, err := json.Marshal(records)
 	if err != nil {
-		return fmt.Errorf("load sources: %w", err)
+		return "", fmt.Errorf("marshal records: %w", err)
 	}
-
-	if len(manifest.Sources) == 0 {
-		return errors.New("no sources to index")
+	if err := os.MkdirAll(s.sourcesDir(model), 0o755); err != nil {
+		return "", err
 	}
+	if err := os.WriteFile(dest, b, 0o644); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
 
-	// Create embeddings client
-	embClient, err := embeddings.NewClient(cfg)
+// readSource reads a source's extracted text, and its records into
+// src.Records.
+func readSource(src *ingest.Source) (string, error) {
+	b, err := os.ReadFile(src.TextPath)
 	if err != nil {
-		return fmt.Errorf("create embeddings client: %w", err)
+		return "", err
 	}
-
-	// Create new index
-	idx := vector.NewIndex()
-
-	// Process each source
-	docID := 0
-	for _, src := range manifest.Sources {
-		// Read text
-		text, err := os.ReadFile(src.TextPath)
+	if src.RecordsPath != "" {
+		r, err := os.ReadFile(src.RecordsPath)
 		if err != nil {
-			continue
+			return "", err
 		}
-
-		// Simple chunking (100 words with 20 word overlap)
-		chunks := simpleChunk(string(text), 100, 20)
-
-		// Generate embeddings for each chunk
-		for chunkIdx, chunk := range chunks {
-			if strings.TrimSpace(chunk) == "" {
-				continue
-			}
-
-			embedding, err := embClient.Embed(ctx, chunk)
-			if err != nil {
-				return fmt.Errorf("embed chunk %d: %w", chunkIdx, err)
-			}
-
-			docID++
-			doc := vector.Document{
-				ID:        fmt.Sprintf("doc_%d", docID),
-				Text:      chunk,
-				Embedding: embedding,
-				Metadata: vector.Metadata{
-					"source":      src.Path,
-					"chunkIdx":    chunkIdx,
-					"totalChunks": len(chunks),
-				},
-			}
-			idx.Add(doc)
+		if err := json.Unmarshal(r, &src.Records); err != nil {
+			return "", fmt.Errorf("%s: %w", src.RecordsPath, err)
 		}
 	}
+	return string(b), nil
+}
 
-	// Save index
-	if err := idx.Save(s.indexPath(model)); err != nil {
-		return fmt.Errorf("save index: %w", err)
+// removeSourceFiles deletes the files extracted from a source.
+func removeSourceFiles(src ingest.Source) {
+	_ = os.Remove(src.TextPath)
+	if src.RecordsPath != "" {
+		_ = os.Remove(src.RecordsPath)
 	}
+}
 
-	// Update model stats
-	meta, err := s.GetModel(model)
+func (s *Store) saveSourcesManifest(m *SourcesManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
-		return fmt.Errorf("get model for stats update: %w", err)
+		return fmt.Errorf("marshal sources: %w", err)
+	}
+	if err := os.WriteFile(s.manifestPath(m.Model), b, 0o644); err != nil {
+		return fmt.Errorf("write sources: %w", err)
 	}
-	meta.Stats.Embeddings = idx.Count()
-	meta.UpdatedAt = time.Now().UTC()
-	mb, err := json.MarshalIndent(meta, "", "  ")
+	return nil
+}
+
+func (s *Store) saveModel(m *ModelMeta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal model metadata: %w", err)
 	}
-	if err := os.WriteFile(s.metaPath(model), mb, 0o644); err != nil {
+	if err := os.WriteFile(s.metaPath(m.Name), b, 0o644); err != nil {
 		return fmt.Errorf("write model metadata: %w", err)
 	}
-
 	return nil
 }
 
-// SearchIndex performs a semantic search on the model's index
-func (s *Store) SearchIndex(ctx context.Context, model string, query string, topK int, cfg embeddings.Config) ([]vector.SearchResult, error) {
-	// Load index
-	idx, err := vector.Load(s.indexPath(model))
-	if err != nil {
-		return nil, fmt.Errorf("load index: %w", err)
-	}
+func (s *Store) indexPath(model string) string {
+	return filepath.Join(s.modelDir(model), "index.bin")
+}
 
-	// Create embeddings client
-	embClient, err := embeddings.NewClient(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("create embeddings client: %w", err)
+// legacyIndexPath is where indexes were stored before the binary format.
+func (s *Store) legacyIndexPath(model string) string {
+	return filepath.Join(s.modelDir(model), "index.json")
+}
+
+// openIndex opens the model's index with the given backend, first converting
+// a legacy JSON index to the binary format if that is all there is.
+func (s *Store) openIndex(model string, cfg vector.Config) (vector.Store, error) {
+	if err := s.migrateIndex(model); err != nil {
+		return nil, fmt.Errorf("migrate index: %w", err)
 	}
+	return vector.OpenStore(s.indexPath(model), cfg)
+}
 
-	// Generate query embedding
-	queryEmb, err := embClient.Embed(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("embed query: %w", err)
+// removeStaleIndexFiles deletes the files other backends keep next to the
+// index, so switching back to them later cannot pick up outdated data.
+func (s *Store) removeStaleIndexFiles(model, backend string) error {
+	files := map[string]string{
+		vector.BackendHNSW: vector.GraphPath(s.indexPath(model)),
+		vector.BackendIVF:  vector.ClustersPath(s.indexPath(model)),
+	}
+	for b, path := range files {
+		if b == backend {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale index file: %w", err)
+		}
 	}
+	return nil
+}
 
-	// Search
-	results, err := idx.Search(queryEmb, topK)
+func (s *Store) migrateIndex(model string) error {
+	if _, err := os.Stat(s.indexPath(model)); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	legacy := s.legacyIndexPath(model)
+	idx, err := vector.Load(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("search: %w", err)
+		return err
 	}
-
-	return results, nil
+	if meta, err := s.GetModel(model); err == nil {
+		idx.EmbeddingModel = meta.EmbeddingModel
+	}
+	if err := idx.Save(s.indexPath(model)); err != nil {
+		return err
+	}
+	return os.Remove(legacy)
 }
 
 func (s *Store) loadSourcesManifest(model string) (*SourcesManifest, error) {
@@ -320,5 +503,6 @@ func (s *Store) loadSourcesManifest(model string) (*SourcesManifest, error) {
 
 // IngestTextSources is kept for compatibility; use IngestSources.
 func (s *Store) IngestTextSources(model, path string) error {
-	return s.IngestSources(model, path)
+	_, err := s.IngestSources(model, path)
+	return err
 }
@@ -0,0 +1,755 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/winzerprince/oc-nlp/internal/chunk"
+	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
+	"github.com/winzerprince/oc-nlp/internal/lexical"
+	"github.com/winzerprince/oc-nlp/internal/retry"
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+func newTestStore(t *testing.T, model string) *Store {
+	t.Helper()
+	s := NewStore(t.TempDir())
+	if _, err := s.CreateModel(model); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIngestSourcesIsAdditive(t *testing.T) {
+	s := newTestStore(t, "m")
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a", "one.txt"), "first folder")
+	writeFile(t, filepath.Join(docs, "b", "two.txt"), "second folder")
+
+	if _, err := s.IngestSources("m", filepath.Join(docs, "a")); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := s.IngestSources("m", filepath.Join(docs, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Added != 1 {
+		t.Fatalf("expected 1 added, got %+v", rep)
+	}
+	m, err := s.loadSourcesManifest("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sources) != 2 {
+		t.Fatalf("expected both folders in manifest, got %d sources", len(m.Sources))
+	}
+	for _, src := range m.Sources {
+		if src.IngestedAt.IsZero() {
+			t.Fatalf("missing ingest timestamp on %s", src.Path)
+		}
+	}
+	meta, err := s.GetModel("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Stats.Sources != 2 {
+		t.Fatalf("expected stats.sources=2, got %d", meta.Stats.Sources)
+	}
+}
+
+func TestIngestSourcesDetectsChanges(t *testing.T) {
+	s := newTestStore(t, "m")
+	docs := t.TempDir()
+	p := filepath.Join(docs, "doc.txt")
+	writeFile(t, p, "version one")
+	writeFile(t, filepath.Join(docs, "unsupported.bin"), "\x01\x02")
+
+	rep, err := s.IngestSources("m", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Added != 1 || rep.Skipped != 1 {
+		t.Fatalf("unexpected first report: %+v", rep)
+	}
+	first, _ := s.loadSourcesManifest("m")
+
+	rep, err = s.IngestSources("m", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Unchanged != 1 || rep.Added != 0 || rep.Updated != 0 {
+		t.Fatalf("expected unchanged re-ingest, got %+v", rep)
+	}
+
+	writeFile(t, p, "version two")
+	rep, err = s.IngestSources("m", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Updated != 1 {
+		t.Fatalf("expected 1 updated, got %+v", rep)
+	}
+	m, _ := s.loadSourcesManifest("m")
+	if len(m.Sources) != 1 {
+		t.Fatalf("changed file should replace its entry, got %d sources", len(m.Sources))
+	}
+	src := m.Sources[0]
+	if src.SHA256 == first.Sources[0].SHA256 {
+		t.Fatal("expected new content hash")
+	}
+	if !src.IngestedAt.Equal(first.Sources[0].IngestedAt) {
+		t.Fatal("ingest timestamp should be preserved on update")
+	}
+	if _, err := os.Stat(first.Sources[0].TextPath); !os.IsNotExist(err) {
+		t.Fatal("stale extracted text should be removed")
+	}
+	b, err := os.ReadFile(src.TextPath)
+	if err != nil || string(b) != "version two" {
+		t.Fatalf("unexpected stored text %q (%v)", b, err)
+	}
+}
+
+func TestIngestSourcesChangeToDuplicate(t *testing.T) {
+	s := newTestStore(t, "m")
+	docs := t.TempDir()
+	a, b := filepath.Join(docs, "a.txt"), filepath.Join(docs, "b.txt")
+	writeFile(t, a, "shared text")
+	writeFile(t, b, "old text")
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.loadSourcesManifest("m")
+
+	writeFile(t, b, "shared text")
+	rep, err := s.IngestSources("m", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Updated != 1 || rep.Unchanged != 1 || rep.Skipped != 0 {
+		t.Fatalf("expected b.txt updated, got %+v", rep)
+	}
+	m, _ := s.loadSourcesManifest("m")
+	if len(m.Sources) != 1 || m.Sources[0].Path != a {
+		t.Fatalf("expected only a.txt to hold the shared text, got %+v", m.Sources)
+	}
+	for _, src := range first.Sources {
+		if src.Path == b {
+			if _, err := os.Stat(src.TextPath); !os.IsNotExist(err) {
+				t.Fatal("old text of b.txt should be removed")
+			}
+		}
+	}
+}
+
+func TestBuildIndexReusesEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a.txt"), "alpha beta gamma")
+	writeFile(t, filepath.Join(docs, "b.txt"), "delta epsilon")
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Embedded != 2 || rep.Reused != 0 {
+		t.Fatalf("unexpected first build: %+v", rep)
+	}
+
+	writeFile(t, filepath.Join(docs, "c.txt"), "zeta eta")
+	if err := os.Remove(filepath.Join(docs, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.RemoveSources("m", filepath.Join(docs, "b.txt")); err != nil || n != 1 {
+		t.Fatalf("remove b.txt: %d %v", n, err)
+	}
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	before := ollama.texts.Load()
+	rep, err = s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Reused != 1 || rep.Embedded != 1 || rep.Removed != 1 || rep.Chunks != 2 {
+		t.Fatalf("unexpected incremental build: %+v", rep)
+	}
+	if got := ollama.texts.Load() - before; got != 1 {
+		t.Fatalf("expected 1 text sent to ollama, got %d", got)
+	}
+
+	rep, err = s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{Full: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Reused != 0 || rep.Embedded != 2 {
+		t.Fatalf("full build should re-embed everything: %+v", rep)
+	}
+}
+
+func TestBuildIndexReembedsOnlyEditedChunks(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	p := filepath.Join(docs, "notes.txt")
+	paragraphs := []string{
+		"The pump starts when the float switch closes.",
+		"Clean the intake filter every spring and autumn.",
+		"Replace the seals if the housing starts to drip.",
+	}
+	writeFile(t, p, strings.Join(paragraphs, "\n\n"))
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	opt := chunk.Options{Strategy: chunk.StrategyStructure, TargetRunes: 60, MinRunes: 0}
+	rep, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{Chunking: &opt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Embedded != 3 {
+		t.Fatalf("expected a chunk per paragraph: %+v", rep)
+	}
+
+	paragraphs[1] = "Clean the intake filter every month."
+	writeFile(t, p, strings.Join(paragraphs, "\n\n"))
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	before := ollama.texts.Load()
+	rep, err = s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Embedded != 1 || rep.Reused != 2 || rep.Removed != 1 || rep.Chunks != 3 {
+		t.Fatalf("expected only the edited paragraph re-embedded: %+v", rep)
+	}
+	if got := ollama.texts.Load() - before; got != 1 {
+		t.Fatalf("expected 1 text sent to ollama, got %d", got)
+	}
+
+	// the reused chunks carry the new content hash of their source
+	idx, err := s.openIndex("m", vector.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	m, _ := s.loadSourcesManifest("m")
+	for _, id := range idx.IDs() {
+		doc, _ := idx.Get(id)
+		if doc.Metadata["sourceSha"] != m.Sources[0].SHA256 {
+			t.Fatalf("stale metadata on %q: %v", doc.Text, doc.Metadata)
+		}
+	}
+}
+
+func TestBuildIndexSwitchesBackend(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a.txt"), "alpha beta gamma")
+	writeFile(t, filepath.Join(docs, "b.txt"), "delta epsilon")
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	hnsw := vector.Config{Backend: vector.BackendHNSW, HNSW: vector.HNSWConfig{M: 8}}
+	rep, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{Index: &hnsw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Index.Backend != vector.BackendHNSW || rep.Index.Params["m"] != 8 {
+		t.Fatalf("unexpected index stats: %+v", rep.Index)
+	}
+	if _, err := os.Stat(vector.GraphPath(s.indexPath("m"))); err != nil {
+		t.Fatalf("graph not saved: %v", err)
+	}
+	meta, _ := s.GetModel("m")
+	if meta.Index.Backend != vector.BackendHNSW {
+		t.Fatalf("backend not persisted: %+v", meta.Index)
+	}
+	results, err := s.SearchIndex(ctx, "m", "alpha", 1, ollama.config())
+	if err != nil || len(results) != 1 {
+		t.Fatalf("search: %v %v", results, err)
+	}
+
+	// switching backend keeps the embeddings and drops the graph file
+	before := ollama.texts.Load()
+	ivf := vector.Config{Backend: vector.BackendIVF}
+	rep, err = s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{Index: &ivf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Reused != 2 || rep.Embedded != 0 || ollama.texts.Load() != before {
+		t.Fatalf("embeddings not reused across backends: %+v", rep)
+	}
+	if _, err := os.Stat(vector.GraphPath(s.indexPath("m"))); !os.IsNotExist(err) {
+		t.Fatalf("stale graph file kept: %v", err)
+	}
+	if results, err := s.SearchIndex(ctx, "m", "delta", 2, ollama.config()); err != nil || len(results) != 2 {
+		t.Fatalf("search: %v %v", results, err)
+	}
+}
+
+func TestSearchFilter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a.txt"), "alpha beta gamma")
+	writeFile(t, filepath.Join(docs, "notes", "b.md"), "alpha delta")
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := vector.ParseFilter("kind=markdown AND source~=/notes/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.Search(ctx, "m", "alpha", SearchOptions{TopK: 5, Filter: filter}, ollama.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Document.Text != "alpha delta" {
+		t.Fatalf("unexpected filtered results: %+v", results)
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a.txt"), "the valve reported fault code XK-9 overnight")
+	writeFile(t, filepath.Join(docs, "b.txt"), "kx kx kx kkk xxx")
+	writeFile(t, filepath.Join(docs, "c.txt"), "routine maintenance notes")
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lexical.Path(s.indexPath("m"))); err != nil {
+		t.Fatalf("lexical index not saved: %v", err)
+	}
+
+	// the letter-frequency embedding prefers b; BM25 finds the exact code in a
+	vec, err := s.Search(ctx, "m", "XK-9", SearchOptions{TopK: 1}, ollama.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vec[0].Document.Text != "kx kx kx kkk xxx" {
+		t.Fatalf("unexpected vector top hit: %q", vec[0].Document.Text)
+	}
+	offline := embeddings.Config{Host: "http://127.0.0.1:1", Model: "fake-embed"}
+	lex, err := s.Search(ctx, "m", "XK-9", SearchOptions{TopK: 1, Mode: ModeLexical}, offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lex) != 1 || !strings.Contains(lex[0].Document.Text, "XK-9") {
+		t.Fatalf("unexpected lexical results: %+v", lex)
+	}
+
+	// with Ollama down, vector search falls back to keyword search
+	offline.Retry = retry.Policy{MaxAttempts: 1}
+	var fellBack error
+	opt := SearchOptions{TopK: 1, Fallback: func(err error) { fellBack = err }}
+	lex, err = s.Search(ctx, "m", `"fault codes"`, opt, offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fellBack == nil || len(lex) != 1 || !strings.Contains(lex[0].Document.Text, "XK-9") {
+		t.Fatalf("no lexical fallback: %v %+v", fellBack, lex)
+	}
+
+	for _, fusion := range []string{FusionRRF, FusionWeighted} {
+		hybrid, err := s.Search(ctx, "m", "XK-9", SearchOptions{TopK: 3, Mode: ModeHybrid, Fusion: fusion}, ollama.config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hybrid) != 3 || !strings.Contains(hybrid[0].Document.Text, "XK-9") {
+			t.Fatalf("%s: exact match not ranked first: %+v", fusion, hybrid)
+		}
+	}
+	if _, err := s.Search(ctx, "m", "x", SearchOptions{TopK: 1, Mode: "fuzzy"}, ollama.config()); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestSearchMMR(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a.txt"), "abc abc abc")
+	writeFile(t, filepath.Join(docs, "b.txt"), "abc abc abc.")
+	writeFile(t, filepath.Join(docs, "c.txt"), "abc xyz")
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := s.Search(ctx, "m", "abc", SearchOptions{TopK: 2}, ollama.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain[0].Document.Text, "xyz") || strings.Contains(plain[1].Document.Text, "xyz") {
+		t.Fatalf("expected the two near-duplicates first: %+v", plain)
+	}
+	for _, mode := range []string{ModeVector, ModeLexical} {
+		lambda := 0.5
+		diverse, err := s.SearchIndexWith(ctx, "m", "abc", SearchOptions{TopK: 2, Mode: mode, MMRLambda: &lambda}, ollama.config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diverse) != 2 || !strings.Contains(diverse[1].Document.Text, "xyz") {
+			t.Fatalf("%s: near-duplicate not replaced: %+v", mode, diverse)
+		}
+	}
+	bad := 2.0
+	if _, err := s.Search(ctx, "m", "abc", SearchOptions{TopK: 2, MMRLambda: &bad}, ollama.config()); err == nil {
+		t.Fatal("expected error for lambda outside [0, 1]")
+	}
+}
+
+func TestIngestCode(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "README.md"), "alpha notes")
+	writeFile(t, filepath.Join(docs, "store.go"), "package store\n\n// Get returns alpha.\nfunc (s *Store) Get() string {\n\treturn \"alpha\"\n}\n")
+	writeFile(t, filepath.Join(docs, ".git", "config.go"), "package git\n")
+
+	rep, err := s.IngestSources("m", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Added != 1 || rep.Skipped != 1 {
+		t.Fatalf("without code mode only the README should be ingested: %+v", rep)
+	}
+	if rep, err = s.IngestSourcesWith("m", docs, IngestOptions{Code: true}); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Added != 1 || rep.Unchanged != 1 {
+		t.Fatalf("code mode should add the Go file and skip .git: %+v", rep)
+	}
+	if _, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := vector.ParseFilter("language=go AND symbol~=Store.Get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.Search(ctx, "m", "alpha", SearchOptions{TopK: 5, Filter: filter}, ollama.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected the Go file's chunk, got %+v", results)
+	}
+	m := results[0].Document.Metadata
+	if m["kind"] != "code" || m["symbol"] != "package store, Store.Get" || m["startLine"] != float64(1) || m["endLine"] != float64(6) {
+		t.Fatalf("unexpected metadata %v", m)
+	}
+}
+
+func TestIngestRows(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "faq.csv"), "question,answer,product\n"+
+		"Does the pump leak?,No,pump\n"+
+		"Does the valve leak?,Rarely,valve\n")
+
+	rows := &ingest.RowOptions{Template: "{{.question}} {{.answer}}", Fields: []string{"product"}}
+	if _, err := s.IngestSourcesWith("m", docs, IngestOptions{Rows: rows}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s.GetModel("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Rows == nil || meta.Rows.Template != rows.Template {
+		t.Fatalf("row options not persisted: %+v", meta.Rows)
+	}
+	// later ingests reuse the stored options, so the source is unchanged
+	rep, err := s.IngestSources("m", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Unchanged != 1 {
+		t.Fatalf("expected the CSV to be unchanged, got %+v", rep)
+	}
+
+	if _, err := s.BuildIndex(ctx, "m", ollama.config(), BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := vector.ParseFilter("product=valve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.Search(ctx, "m", "leak", SearchOptions{TopK: 5, Filter: filter}, ollama.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Document.Text != "Does the valve leak? Rarely" {
+		t.Fatalf("expected the valve row alone, got %+v", results)
+	}
+	if m := results[0].Document.Metadata; m["kind"] != "csv" || m["row"] != float64(2) {
+		t.Fatalf("unexpected metadata %v", m)
+	}
+
+	// row spans live beside the text, not in sources.json
+	manifest, err := s.loadSourcesManifest("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := manifest.Sources[0].RecordsPath
+	if _, err := os.Stat(records); err != nil {
+		t.Fatalf("records file missing: %v", err)
+	}
+	if b, _ := os.ReadFile(s.manifestPath("m")); strings.Contains(string(b), "valve") {
+		t.Fatalf("sources.json holds row metadata:\n%s", b)
+	}
+	if _, err := s.RemoveSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(records); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("records file not removed: %v", err)
+	}
+
+	bad := &ingest.RowOptions{Fields: []string{"source"}}
+	if _, err := s.IngestSourcesWith("m", docs, IngestOptions{Rows: bad}); err == nil {
+		t.Fatal("expected an error for a reserved field name")
+	}
+}
+
+func TestBuildIndexPersistsChunking(t *testing.T) {
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	text := "one two three four five six seven eight nine ten eleven twelve"
+	writeFile(t, filepath.Join(docs, "a.txt"), text)
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := chunk.Options{TargetRunes: 20, OverlapRunes: 5, MinRunes: 5}
+	if _, err := s.BuildIndex(context.Background(), "m", ollama.config(), BuildOptions{Chunking: &opt}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s.GetModel("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ChunkOptions() != opt {
+		t.Fatalf("chunk options not persisted: %+v", meta.Chunking)
+	}
+
+	idx, err := vector.Load(s.indexPath("m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Count() < 2 {
+		t.Fatalf("expected several chunks, got %d", idx.Count())
+	}
+	runes := []rune(text)
+	for _, doc := range idx.Documents {
+		start, _ := doc.Metadata["start"].(float64)
+		end, _ := doc.Metadata["end"].(float64)
+		if got := string(runes[int(start):int(end)]); got != doc.Text {
+			t.Fatalf("offsets [%v,%v) give %q, want %q", start, end, got, doc.Text)
+		}
+	}
+}
+
+func TestBuildIndexResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	docs := t.TempDir()
+	for i, text := range []string{"alpha", "beta", "gamma", "delta", "epsilon"} {
+		writeFile(t, filepath.Join(docs, fmt.Sprintf("%d.txt", i)), text)
+	}
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := ollama.config()
+	cfg.BatchSize, cfg.Concurrency = 1, 1
+	ollama.limit.Store(3)
+	if _, err := s.BuildIndex(ctx, "m", cfg, BuildOptions{CheckpointEvery: 2}); err == nil {
+		t.Fatal("expected the build to fail")
+	}
+	if _, err := os.Stat(s.checkpointPath("m")); err != nil {
+		t.Fatalf("checkpoint should be kept after a failed build: %v", err)
+	}
+	if _, err := os.Stat(s.indexPath("m")); !os.IsNotExist(err) {
+		t.Fatal("no index should be written by a failed build")
+	}
+
+	ollama.limit.Store(0)
+	rep, err := s.BuildIndex(ctx, "m", cfg, BuildOptions{CheckpointEvery: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Resumed != 2 || rep.Embedded != 3 || rep.Chunks != 5 {
+		t.Fatalf("unexpected resumed build: %+v", rep)
+	}
+	if _, err := os.Stat(s.checkpointPath("m")); !os.IsNotExist(err) {
+		t.Fatal("checkpoint should be removed after a successful build")
+	}
+}
+
+func TestLoadCheckpointIgnoresTruncatedLine(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "cp.jsonl")
+	writeFile(t, p, `{"model":"a","id":"1","embedding":[1,2]}
+{"model":"b","id":"2","embedding":[3,4]}
+{"model":"a","id":"3","embed`)
+	got, err := loadCheckpoint(p, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["1"] == nil {
+		t.Fatalf("unexpected checkpoint contents: %v", got)
+	}
+}
+
+func TestGetSourceText(t *testing.T) {
+	s := newTestStore(t, "m")
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "a.md"), "# Pumps\n\n"+strings.Repeat("The pump runs at 40 bar. ", 80))
+	if _, err := s.IngestSources("m", docs); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.loadSourcesManifest("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := manifest.Sources[0]
+
+	st, err := s.GetSourceText("m", src.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := s.GetModel("m")
+	want := splitSource(src, st.Text, meta.ChunkOptions())
+	if len(st.Chunks) < 2 || len(st.Chunks) != len(want) || st.Chunks[1].Start != want[1].Start {
+		t.Fatalf("got %d chunks, want the %d BuildIndex makes", len(st.Chunks), len(want))
+	}
+	if _, err := s.GetSourceText("m", "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want ErrNotExist for an unknown source, got %v", err)
+	}
+}
+
+func TestConversations(t *testing.T) {
+	s := newTestStore(t, "m")
+	if list, err := s.ListConversations("m"); err != nil || len(list) != 0 {
+		t.Fatalf("want no conversations, got %v, %v", list, err)
+	}
+	c, err := s.NewConversation("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddTurn("Which valves need closing?", "Which valves need closing?", "V-12.")
+	c.AddTurn("and the second one?", "second valve to close before maintenance", "V-7.")
+	if err := s.SaveConversation(c); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetConversation("m", c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Which valves need closing?" || len(got.Turns) != 2 {
+		t.Fatalf("unexpected conversation: %+v", got)
+	}
+	if got.Turns[0].Query != "" || got.Turns[1].Query != "second valve to close before maintenance" {
+		t.Fatalf("unexpected queries: %+v", got.Turns)
+	}
+	if list, err := s.ListConversations("m"); err != nil || len(list) != 1 || list[0].ID != c.ID {
+		t.Fatalf("unexpected list: %v, %v", list, err)
+	}
+
+	if _, err := s.GetConversation("m", "../model"); err == nil {
+		t.Fatal("expected an error for an invalid id")
+	}
+	if err := s.DeleteConversation("../m", c.ID); err == nil {
+		t.Fatal("expected an error for an invalid model name")
+	}
+	if _, err := s.ListConversations("../m"); err == nil {
+		t.Fatal("expected an error for an invalid model name")
+	}
+	if err := s.DeleteConversation("m", c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetConversation("m", c.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestSearchIndexMigratesLegacyJSON(t *testing.T) {
+	s := newTestStore(t, "m")
+	ollama := newFakeOllama(t)
+	legacy := vector.NewIndex()
+	legacy.Add(vector.Document{ID: "a", Text: "aaa", Embedding: toFloat64(letterVector("aaa"))})
+	legacy.Add(vector.Document{ID: "b", Text: "bbb", Embedding: toFloat64(letterVector("bbb"))})
+	b, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, s.legacyIndexPath("m"), string(b))
+
+	results, err := s.SearchIndex(context.Background(), "m", "bb", 1, ollama.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Document.ID != "b" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if _, err := os.Stat(s.legacyIndexPath("m")); !os.IsNotExist(err) {
+		t.Fatal("legacy index should be replaced by the binary one")
+	}
+	if _, err := os.Stat(s.indexPath("m")); err != nil {
+		t.Fatalf("binary index missing: %v", err)
+	}
+}
+
+func toFloat64(v []float32) []float64 {
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = float64(x)
+	}
+	return out
+}
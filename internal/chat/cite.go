@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+// Citation is a retrieved passage that the answer cites as [N].
+type Citation struct {
+	N         int    // passage number in the prompt, from 1
+	Source    string // path of the source file
+	SourceSHA string
+	ChunkIdx  int
+	Start     int // rune offsets of the passage in the extracted text
+	End       int
+	Page      int // page the passage starts on, 0 if the source has no pages
+}
+
+// CitationPattern matches citation markers such as [2] or [1, 3]; the
+// submatch is the list of passage numbers.
+var CitationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// citedPassages returns the passage numbers cited in answer, from 1 to n, in
+// order of first citation.
+func citedPassages(answer string, n int) []int {
+	var out []int
+	seen := map[int]bool{}
+	for _, m := range CitationPattern.FindAllStringSubmatch(answer, -1) {
+		for _, part := range strings.Split(m[1], ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || i < 1 || i > n || seen[i] {
+				continue
+			}
+			seen[i] = true
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// citations resolves the passages cited in answer.
+func citations(answer string, retrieved []Retrieved) []Citation {
+	var out []Citation
+	for _, n := range citedPassages(answer, len(retrieved)) {
+		r := retrieved[n-1]
+		out = append(out, Citation{
+			N:         n,
+			Source:    r.Source,
+			SourceSHA: r.SourceSHA,
+			ChunkIdx:  r.ChunkIdx,
+			Start:     r.Start,
+			End:       r.End,
+			Page:      r.Page,
+		})
+	}
+	return out
+}
+
+// retrievedFrom describes a search result, reading its location from the
+// chunk metadata.
+func retrievedFrom(r vector.SearchResult) Retrieved {
+	m := r.Document.Metadata
+	heading, _ := m["heading"].(string)
+	source, _ := m["source"].(string)
+	sha, _ := m["sourceSha"].(string)
+	return Retrieved{
+		Text:      r.Document.Text,
+		Score:     r.Score,
+		Heading:   heading,
+		Source:    source,
+		SourceSHA: sha,
+		ChunkIdx:  metaInt(m, "chunkIdx"),
+		Start:     metaInt(m, "start"),
+		End:       metaInt(m, "end"),
+		Page:      metaInt(m, "page"),
+	}
+}
+
+// metaInt reads an integer from metadata, which holds float64 once it has
+// been through JSON.
+func metaInt(m vector.Metadata, key string) int {
+	switch v := m[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
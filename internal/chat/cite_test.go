@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+func TestCitedPassages(t *testing.T) {
+	answer := "Close V-12 first [2]. The pump runs at 40 bar [1, 3][2]; see also [7] and [0] and [x]."
+	if got, want := citedPassages(answer, 3), []int{2, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := citedPassages("No citations here.", 3); got != nil {
+		t.Fatalf("got %v, want none", got)
+	}
+}
+
+func TestCitations(t *testing.T) {
+	// metadata read back from disk holds float64
+	results := []vector.SearchResult{
+		{Document: vector.Document{Text: "a", Metadata: vector.Metadata{"source": "/docs/a.pdf", "sourceSha": "aaa", "chunkIdx": 4.0, "start": 3600.0, "end": 4500.0, "page": 7.0}}, Score: 0.9},
+		{Document: vector.Document{Text: "b", Metadata: vector.Metadata{"source": "/docs/b.md", "sourceSha": "bbb", "chunkIdx": 0, "start": 0, "end": 120}}, Score: 0.8},
+	}
+	retrieved := []Retrieved{retrievedFrom(results[0]), retrievedFrom(results[1])}
+	got := citations("Yes [2], and on page 7 [1].", retrieved)
+	want := []Citation{
+		{N: 2, Source: "/docs/b.md", SourceSHA: "bbb", ChunkIdx: 0, Start: 0, End: 120},
+		{N: 1, Source: "/docs/a.pdf", SourceSHA: "aaa", ChunkIdx: 4, Start: 3600, End: 4500, Page: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
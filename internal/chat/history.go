@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/winzerprince/oc-nlp/internal/app"
+	"github.com/winzerprince/oc-nlp/internal/llm"
+)
+
+// DefaultHistoryTokens is the prompt budget for earlier turns when
+// Options.HistoryTokens is not set.
+const DefaultHistoryTokens = 1500
+
+// estimateTokens approximates the token count of s at four characters per
+// token, which is close enough for budgeting English text.
+func estimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+// trimHistory returns the most recent turns whose questions and answers fit
+// in budget tokens, oldest first.
+func trimHistory(turns []app.Turn, budget int) []app.Turn {
+	used, start := 0, len(turns)
+	for start > 0 {
+		t := turns[start-1]
+		n := estimateTokens(t.Question) + estimateTokens(t.Answer)
+		if used+n > budget {
+			break
+		}
+		used += n
+		start--
+	}
+	return turns[start:]
+}
+
+func writeHistory(b *strings.Builder, turns []app.Turn) {
+	for _, t := range turns {
+		b.WriteString("User: " + t.Question + "\n")
+		b.WriteString("Assistant: " + t.Answer + "\n\n")
+	}
+}
+
+// condense rewrites a follow-up question such as "what about the second
+// one?" into a standalone search query using the conversation so far. It
+// falls back to the question when the model returns nothing usable.
+func condense(ctx context.Context, client *llm.Client, history []app.Turn, question string) (string, error) {
+	var b strings.Builder
+	b.WriteString("Rewrite the FOLLOW-UP question as a standalone search query that can be understood without the CONVERSATION. Keep names, numbers and identifiers. Reply with the query only.\n\n")
+	b.WriteString("CONVERSATION:\n")
+	writeHistory(&b, history)
+	b.WriteString("FOLLOW-UP: " + question + "\n")
+	b.WriteString("STANDALONE QUERY:")
+	reply, err := client.Generate(ctx, b.String())
+	if err != nil {
+		return "", err
+	}
+	query, _, _ := strings.Cut(strings.TrimSpace(reply), "\n")
+	query = strings.Trim(strings.TrimSpace(query), `"'`)
+	if query == "" {
+		return question, nil
+	}
+	return query, nil
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/winzerprince/oc-nlp/internal/app"
+	"github.com/winzerprince/oc-nlp/internal/llm"
+	"github.com/winzerprince/oc-nlp/internal/retry"
+)
+
+func TestTrimHistory(t *testing.T) {
+	turns := []app.Turn{
+		{Question: strings.Repeat("a", 400), Answer: strings.Repeat("b", 400)}, // 200 tokens
+		{Question: "second?", Answer: "yes"},
+		{Question: "third?", Answer: "no"},
+	}
+	if got := trimHistory(turns, 1000); len(got) != 3 {
+		t.Fatalf("want all turns, got %d", len(got))
+	}
+	got := trimHistory(turns, 50)
+	if len(got) != 2 || got[0].Question != "second?" {
+		t.Fatalf("want the last two turns, got %+v", got)
+	}
+	if got := trimHistory(turns, 0); len(got) != 0 {
+		t.Fatalf("want no turns, got %d", len(got))
+	}
+}
+
+func TestCondense(t *testing.T) {
+	var prompt string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Prompt
+		_ = json.NewEncoder(w).Encode(map[string]any{"response": "\"pressure rating of valve V-7\"\nbecause...", "done": true})
+	}))
+	defer srv.Close()
+
+	client, err := llm.NewClient(llm.Config{Host: srv.URL, Model: "fake", Retry: retry.Policy{MaxAttempts: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := []app.Turn{{Question: "Which valves are there?", Answer: "V-12 and V-7."}}
+	got, err := condense(context.Background(), client, history, "what about the second one?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "pressure rating of valve V-7" {
+		t.Fatalf("got %q", got)
+	}
+	if !strings.Contains(prompt, "Assistant: V-12 and V-7.") || !strings.Contains(prompt, "FOLLOW-UP: what about the second one?") {
+		t.Fatalf("history missing from prompt:\n%s", prompt)
+	}
+}
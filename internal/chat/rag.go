@@ -12,47 +12,153 @@ import (
 )
 
 type Retrieved struct {
-	Text  string
-	Score float64
+	Text    string
+	Score   float64
+	Heading string // markdown heading breadcrumb, if any
+	// Where the passage comes from: the source file, the chunk and its rune
+	// offsets in the extracted text, and the page for paginated sources.
+	Source    string
+	SourceSHA string
+	ChunkIdx  int
+	Start     int
+	End       int
+	Page      int
+	// RerankScore is the reranker's score when Reranked is set.
+	RerankScore float64
+	Reranked    bool
 }
 
 type Result struct {
-	Answer          string
+	Answer string
+	// Query is what was searched for: the question, or its standalone
+	// rewrite when there is conversation history.
+	Query           string
 	Retrieved       []Retrieved
 	AssembledPrompt string
+	// Citations are the retrieved passages the answer cites by number.
+	Citations []Citation
+}
+
+// Options controls retrieval for AskWith.
+type Options struct {
+	app.SearchOptions
+	// Reranker, if set, rescores RerankCandidates retrieved chunks (default
+	// DefaultRerankCandidates) and the best TopK of them are used.
+	Reranker         Reranker
+	RerankCandidates int
+	// History holds the conversation's earlier turns, oldest first. The most
+	// recent ones that fit in HistoryTokens (default DefaultHistoryTokens) go
+	// into the prompt, and the question is rewritten into a standalone search
+	// query using them.
+	History       []app.Turn
+	HistoryTokens int
 }
 
 func Ask(ctx context.Context, store *app.Store, modelName string, query string, topK int, embCfg embeddings.Config, llmCfg llm.Config) (*Result, error) {
-	results, err := store.SearchIndex(ctx, modelName, query, topK, embCfg)
+	return AskWith(ctx, store, modelName, query, Options{SearchOptions: app.SearchOptions{TopK: topK}}, embCfg, llmCfg)
+}
+
+// AskWith is Ask with retrieval options.
+func AskWith(ctx context.Context, store *app.Store, modelName string, query string, opt Options, embCfg embeddings.Config, llmCfg llm.Config) (*Result, error) {
+	client, err := llm.NewClient(llmCfg)
 	if err != nil {
 		return nil, err
 	}
-
-	retrieved := make([]Retrieved, 0, len(results))
-	for _, r := range results {
-		retrieved = append(retrieved, Retrieved{Text: r.Document.Text, Score: r.Score})
+	res, err := retrieve(ctx, store, client, modelName, query, opt, embCfg)
+	if err != nil {
+		return nil, err
+	}
+	ans, err := client.Generate(ctx, res.AssembledPrompt)
+	if err != nil {
+		return nil, err
 	}
+	res.Answer = strings.TrimSpace(ans)
+	res.Citations = citations(res.Answer, res.Retrieved)
+	return res, nil
+}
 
-	prompt := assemblePrompt(query, results)
+// AskStream is AskWith with the answer streamed. onRetrieved is called with
+// the passages and prompt before generation starts, then onToken with each
+// piece of the answer as the model produces it. An error from either callback
+// stops the answer and is returned.
+func AskStream(ctx context.Context, store *app.Store, modelName string, query string, opt Options, embCfg embeddings.Config, llmCfg llm.Config, onRetrieved func(*Result) error, onToken func(string) error) (*Result, error) {
 	client, err := llm.NewClient(llmCfg)
 	if err != nil {
 		return nil, err
 	}
-	ans, err := client.Generate(ctx, prompt)
+	res, err := retrieve(ctx, store, client, modelName, query, opt, embCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := onRetrieved(res); err != nil {
+		return nil, err
+	}
+	ans, err := client.GenerateStream(ctx, res.AssembledPrompt, onToken)
+	if err != nil {
+		return nil, err
+	}
+	res.Answer = strings.TrimSpace(ans)
+	res.Citations = citations(res.Answer, res.Retrieved)
+	return res, nil
+}
+
+// retrieve searches (and reranks) the passages for question and assembles
+// the prompt; the returned Result has no answer yet.
+func retrieve(ctx context.Context, store *app.Store, client *llm.Client, modelName string, question string, opt Options, embCfg embeddings.Config) (*Result, error) {
+	budget := opt.HistoryTokens
+	if budget <= 0 {
+		budget = DefaultHistoryTokens
+	}
+	history := trimHistory(opt.History, budget)
+	query := question
+	if len(history) > 0 {
+		var err error
+		if query, err = condense(ctx, client, history, question); err != nil {
+			return nil, fmt.Errorf("condense question: %w", err)
+		}
+	}
+
+	search := opt.SearchOptions
+	if opt.Reranker != nil {
+		search.TopK = max(opt.RerankCandidates, opt.TopK)
+		if opt.RerankCandidates <= 0 {
+			search.TopK = max(DefaultRerankCandidates, opt.TopK)
+		}
+	}
+	results, err := store.Search(ctx, modelName, query, search, embCfg)
 	if err != nil {
 		return nil, err
 	}
+	var rerankScores []float64
+	if opt.Reranker != nil {
+		if results, rerankScores, err = rerank(ctx, opt.Reranker, query, results, opt.TopK); err != nil {
+			return nil, err
+		}
+	}
 
-	return &Result{Answer: strings.TrimSpace(ans), Retrieved: retrieved, AssembledPrompt: prompt}, nil
+	retrieved := make([]Retrieved, 0, len(results))
+	for i, r := range results {
+		ret := retrievedFrom(r)
+		if rerankScores != nil {
+			ret.RerankScore, ret.Reranked = rerankScores[i], true
+		}
+		retrieved = append(retrieved, ret)
+	}
+	return &Result{Query: query, Retrieved: retrieved, AssembledPrompt: assemblePrompt(question, history, results)}, nil
 }
 
-func assemblePrompt(query string, results []vector.SearchResult) string {
+func assemblePrompt(query string, history []app.Turn, results []vector.SearchResult) string {
 	var b strings.Builder
-	b.WriteString("You are a helpful assistant. Use the provided CONTEXT to answer the QUESTION. If the answer is not in the context, say you don't know.\n\n")
+	b.WriteString("You are a helpful assistant. Use the provided CONTEXT to answer the QUESTION. If the answer is not in the context, say you don't know.\n")
+	b.WriteString("Cite the passages you use by their number in square brackets, like [1] or [2, 3].\n\n")
 	b.WriteString("CONTEXT:\n")
 	for i, r := range results {
 		b.WriteString(fmt.Sprintf("[%d] (score=%.4f) %s\n\n", i+1, r.Score, r.Document.Text))
 	}
+	if len(history) > 0 {
+		b.WriteString("CONVERSATION SO FAR:\n")
+		writeHistory(&b, history)
+	}
 	b.WriteString("QUESTION: " + query + "\n")
 	b.WriteString("ANSWER:\n")
 	return b.String()
@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/winzerprince/oc-nlp/internal/llm"
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+// DefaultRerankCandidates is how many chunks are retrieved for reranking
+// when Options.RerankCandidates is not set.
+const DefaultRerankCandidates = 50
+
+// Reranker rescores retrieved chunks against the query. It returns one score
+// per candidate, higher meaning more relevant.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, candidates []vector.SearchResult) ([]float64, error)
+}
+
+// LLMReranker asks an Ollama model to rate each passage's relevance to the
+// query from 0 to 10, and scores it rating/10.
+type LLMReranker struct {
+	Config      llm.Config
+	Concurrency int // passages rated at once (default 4)
+}
+
+// NewLLMReranker creates a reranker that uses the model in cfg.
+func NewLLMReranker(cfg llm.Config) *LLMReranker {
+	return &LLMReranker{Config: cfg, Concurrency: 4}
+}
+
+var ratingRe = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// Rerank rates every candidate. A reply without a number scores 0.
+func (r *LLMReranker) Rerank(ctx context.Context, query string, candidates []vector.SearchResult) ([]float64, error) {
+	client, err := llm.NewClient(r.Config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scores := make([]float64, len(candidates))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for range max(r.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				reply, err := client.Generate(ctx, ratingPrompt(query, candidates[i].Document.Text))
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("rerank passage %d: %w", i+1, err)
+						cancel()
+					})
+					continue
+				}
+				if m := ratingRe.FindString(reply); m != "" {
+					v, _ := strconv.ParseFloat(m, 64)
+					scores[i] = min(max(v, 0), 10) / 10
+				}
+			}
+		}()
+	}
+	for i := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return scores, nil
+}
+
+func ratingPrompt(query, passage string) string {
+	return "Rate how relevant the PASSAGE is for answering the QUERY, from 0 (irrelevant) to 10 (answers it directly). Reply with the number only.\n\n" +
+		"QUERY: " + query + "\n\n" +
+		"PASSAGE:\n" + passage + "\n\n" +
+		"RATING:"
+}
+
+// rerank scores results with r and returns the topK best with their scores,
+// keeping the retrieval order between equal scores.
+func rerank(ctx context.Context, r Reranker, query string, results []vector.SearchResult, topK int) ([]vector.SearchResult, []float64, error) {
+	scores, err := r.Rerank(ctx, query, results)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(scores) != len(results) {
+		return nil, nil, fmt.Errorf("reranker returned %d scores for %d passages", len(scores), len(results))
+	}
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	order = order[:min(topK, len(order))]
+
+	outResults := make([]vector.SearchResult, len(order))
+	outScores := make([]float64, len(order))
+	for i, j := range order {
+		outResults[i], outScores[i] = results[j], scores[j]
+	}
+	return outResults, outScores, nil
+}
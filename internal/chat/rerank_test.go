@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/winzerprince/oc-nlp/internal/llm"
+	"github.com/winzerprince/oc-nlp/internal/retry"
+	"github.com/winzerprince/oc-nlp/internal/vector"
+)
+
+func results(texts ...string) []vector.SearchResult {
+	out := make([]vector.SearchResult, len(texts))
+	for i, t := range texts {
+		out[i] = vector.SearchResult{Document: vector.Document{ID: t, Text: t}, Score: 1 - float64(i)/10}
+	}
+	return out
+}
+
+func TestLLMReranker(t *testing.T) {
+	// the fake model rates passages mentioning "valve" highly
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		passage := req.Prompt[strings.Index(req.Prompt, "PASSAGE:"):]
+		reply := "Rating: 2"
+		switch {
+		case strings.Contains(passage, "valve"):
+			reply = "9"
+		case strings.Contains(passage, "gibberish"):
+			reply = "I cannot rate this."
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"response": reply, "done": true})
+	}))
+	defer srv.Close()
+
+	rr := NewLLMReranker(llm.Config{Host: srv.URL, Model: "fake", Retry: retry.Policy{MaxAttempts: 1}})
+	got, scores, err := rerank(context.Background(), rr, "which valve?", results("pump notes", "gibberish", "valve V-12 spec"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Document.Text != "valve V-12 spec" || got[1].Document.Text != "pump notes" {
+		t.Fatalf("unexpected order: %+v", got)
+	}
+	if scores[0] != 0.9 || scores[1] != 0.2 {
+		t.Fatalf("unexpected scores: %v", scores)
+	}
+}
+
+type fixedReranker []float64
+
+func (f fixedReranker) Rerank(context.Context, string, []vector.SearchResult) ([]float64, error) {
+	return f, nil
+}
+
+func TestRerankKeepsOrderOfTies(t *testing.T) {
+	got, _, err := rerank(context.Background(), fixedReranker{0.5, 0.9, 0.5}, "q", results("a", "b", "c"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Document.ID != "b" || got[1].Document.ID != "a" || got[2].Document.ID != "c" {
+		t.Fatalf("unexpected order: %+v", got)
+	}
+	if _, _, err := rerank(context.Background(), fixedReranker{1}, "q", results("a", "b"), 2); err == nil {
+		t.Fatal("expected error for a score count mismatch")
+	}
+}
@@ -3,7 +3,8 @@ package chunk
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"strings"
+	"errors"
+	"fmt"
 	"unicode"
 )
 
@@ -11,39 +12,102 @@ type Chunk struct {
 	ID     string `json:"id"`
 	Index  int    `json:"index"`
 	Text   string `json:"text"`
-	Start  int    `json:"start"`  // rune offset
-	End    int    `json:"end"`    // rune offset
+	Start  int    `json:"start"`  // rune offset into the original text
+	End    int    `json:"end"`    // rune offset into the original text (exclusive)
 	Source string `json:"source"` // source sha or path
+	// Heading is the breadcrumb of markdown headings above the chunk, e.g.
+	// "Install > Linux > Troubleshooting". Only set by SplitMarkdown.
+	Heading string `json:"heading,omitempty"`
+	// Symbol names the declarations in a chunk of source code, e.g.
+	// "Store.BuildIndex", and StartLine and EndLine give its line range,
+	// from 1. Only set by SplitCode.
+	Symbol    string `json:"symbol,omitempty"`
+	StartLine int    `json:"startLine,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
 }
 
+// HeadingSeparator joins heading titles in Chunk.Heading.
+const HeadingSeparator = " > "
+
+// Chunking strategies selectable through Options.Strategy.
+const (
+	StrategyRunes     = "runes"     // fixed-size rune windows (SplitRunes)
+	StrategyStructure = "structure" // paragraphs, sentences and headings (SplitStructure)
+	StrategyMarkdown  = "markdown"  // markdown heading hierarchy (SplitMarkdown)
+)
+
 type Options struct {
-	TargetRunes int
-	OverlapRunes int
-	MinRunes    int
-	Dedupe      bool
+	Strategy     string `json:"strategy,omitempty"` // empty means StrategyRunes
+	TargetRunes  int    `json:"targetRunes"`
+	OverlapRunes int    `json:"overlapRunes"`
+	MinRunes     int    `json:"minRunes"`
+	Dedupe       bool   `json:"dedupe"`
+	// PrependHeading prefixes each chunk's text with its heading breadcrumb so
+	// the section context is part of what gets embedded (markdown only).
+	PrependHeading bool `json:"prependHeading,omitempty"`
 }
 
 func DefaultOptions() Options {
-	return Options{TargetRunes: 900, OverlapRunes: 180, MinRunes: 120, Dedupe: true}
+	return Options{Strategy: StrategyRunes, TargetRunes: 900, OverlapRunes: 180, MinRunes: 120, Dedupe: true}
+}
+
+// Validate reports options that cannot produce sensible chunks.
+func (o Options) Validate() error {
+	switch o.Strategy {
+	case "", StrategyRunes, StrategyStructure, StrategyMarkdown:
+	default:
+		return fmt.Errorf("unknown chunk strategy %q", o.Strategy)
+	}
+	if o.TargetRunes <= 0 {
+		return errors.New("chunk size must be positive")
+	}
+	if o.OverlapRunes < 0 || o.OverlapRunes >= o.TargetRunes {
+		return errors.New("chunk overlap must be between 0 and the chunk size")
+	}
+	if o.MinRunes < 0 || o.MinRunes > o.TargetRunes {
+		return errors.New("minimum chunk size must be between 0 and the chunk size")
+	}
+	return nil
 }
 
-func normalizeWS(s string) string {
-	// collapse whitespace to single spaces to keep chunk boundaries stable-ish
-	var b strings.Builder
-	b.Grow(len(s))
-	prevSpace := false
+// Split chunks text with the strategy selected in opt.
+func Split(text, source string, opt Options) []Chunk {
+	switch opt.Strategy {
+	case StrategyStructure:
+		return SplitStructure(text, source, opt)
+	case StrategyMarkdown:
+		return SplitMarkdown(text, source, opt)
+	default:
+		return SplitRunes(text, source, opt)
+	}
+}
+
+// normalizeWS collapses whitespace to single spaces to keep chunk boundaries
+// stable-ish. Alongside the normalized runes it returns, for each of them, the
+// rune offset it came from in s, so chunk offsets can point into the original.
+func normalizeWS(s string) ([]rune, []int) {
+	out := make([]rune, 0, len(s))
+	pos := make([]int, 0, len(s))
+	pendingSpace := -1
+	i := 0
 	for _, r := range s {
 		if unicode.IsSpace(r) {
-			if !prevSpace {
-				b.WriteByte(' ')
-				prevSpace = true
+			if pendingSpace == -1 && len(out) > 0 {
+				pendingSpace = i
 			}
+			i++
 			continue
 		}
-		prevSpace = false
-		b.WriteRune(r)
+		if pendingSpace != -1 {
+			out = append(out, ' ')
+			pos = append(pos, pendingSpace)
+			pendingSpace = -1
+		}
+		out = append(out, r)
+		pos = append(pos, i)
+		i++
 	}
-	return strings.TrimSpace(b.String())
+	return out, pos
 }
 
 func hashText(s string) string {
@@ -51,14 +115,18 @@ func hashText(s string) string {
 	return hex.EncodeToString(h[:])
 }
 
+// ID returns the content hash used as Chunk.ID for text taken from source.
+func ID(source, text string) string {
+	return hashText(source + "\n" + text)
+}
+
 // SplitRunes chunks text using rune counts with overlap.
 // It tries to break on spaces near the target length when possible.
 func SplitRunes(text, source string, opt Options) []Chunk {
 	if opt.TargetRunes <= 0 {
 		opt = DefaultOptions()
 	}
-	norm := normalizeWS(text)
-	r := []rune(norm)
+	r, pos := normalizeWS(text)
 	if len(r) == 0 {
 		return nil
 	}
@@ -92,19 +160,28 @@ func SplitRunes(text, source string, opt Options) []Chunk {
 			}
 		}
 
-		chunkText := strings.TrimSpace(string(r[start:end]))
-		if len([]rune(chunkText)) < opt.MinRunes {
+		lo, hi := start, end
+		for lo < hi && r[lo] == ' ' {
+			lo++
+		}
+		for hi > lo && r[hi-1] == ' ' {
+			hi--
+		}
+		chunkText := string(r[lo:hi])
+		// a text shorter than MinRunes still yields one chunk; only short
+		// trailing windows (already covered by the overlap) are dropped
+		if hi-lo < opt.MinRunes && (len(out) > 0 || hi == lo) {
 			break
 		}
 
-		id := hashText(source + "\n" + chunkText)
+		id := ID(source, chunkText)
 		if opt.Dedupe {
 			if seen[id] {
 				continue
 			}
 			seen[id] = true
 		}
-		out = append(out, Chunk{ID: id, Index: idx, Text: chunkText, Start: start, End: end, Source: source})
+		out = append(out, Chunk{ID: id, Index: idx, Text: chunkText, Start: pos[lo], End: pos[hi-1] + 1, Source: source})
 		idx++
 
 		if end >= len(r) {
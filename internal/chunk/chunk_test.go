@@ -34,3 +34,29 @@ func TestDedupe(t *testing.T) {
 		seen[c.ID] = true
 	}
 }
+
+func TestSplitRunesOffsetsPointIntoOriginal(t *testing.T) {
+	text := "  alpha   beta\n\ngamma delta\tepsilon zeta eta theta iota kappa  "
+	opt := Options{TargetRunes: 16, OverlapRunes: 4, MinRunes: 1}
+	chunks := SplitRunes(text, "src", opt)
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(chunks))
+	}
+	orig := []rune(text)
+	for _, c := range chunks {
+		span := string(orig[c.Start:c.End])
+		if got, _ := normalizeWS(span); string(got) != c.Text {
+			t.Fatalf("span [%d,%d) = %q does not match chunk text %q", c.Start, c.End, span, c.Text)
+		}
+	}
+}
+
+func TestSplitRunesShortText(t *testing.T) {
+	chunks := SplitRunes("tiny note", "src", DefaultOptions())
+	if len(chunks) != 1 || chunks[0].Text != "tiny note" {
+		t.Fatalf("short text should yield a single chunk, got %#v", chunks)
+	}
+	if chunks := SplitRunes(" \n\t ", "src", DefaultOptions()); len(chunks) != 0 {
+		t.Fatalf("blank text should yield no chunks, got %#v", chunks)
+	}
+}
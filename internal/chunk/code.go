@@ -0,0 +1,462 @@
+package chunk
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// indentLanguages are the languages whose blocks SplitCode finds by
+// indentation rather than braces.
+var indentLanguages = map[string]bool{"python": true, "ruby": true}
+
+// SplitCode chunks source code along its top-level declarations: functions,
+// types, classes and the like. Go is parsed with go/parser; other languages
+// are cut where brace depth (or, for Python and Ruby, indentation) returns
+// to the top level after a block or a blank line. Small neighbouring
+// declarations are packed together up to TargetRunes; longer ones are cut
+// between the declarations nested in them and, failing that, between lines.
+// Text is kept verbatim and there is no overlap. Each chunk names its
+// declarations in Chunk.Symbol and its line range in StartLine and EndLine.
+func SplitCode(text, source, language string, opt Options) []Chunk {
+	if opt.TargetRunes <= 0 {
+		opt = DefaultOptions()
+	}
+	s := &codeSplitter{r: []rune(text), target: opt.TargetRunes}
+	s.lines = codeLines(s.r, indentLanguages[language])
+	if len(s.lines) == 0 {
+		return nil
+	}
+
+	var units []codeUnit
+	if language == "go" {
+		units = s.goUnits(text)
+	}
+	if units == nil {
+		units = s.heuristicUnits()
+	}
+
+	seen := map[string]bool{}
+	out := make([]Chunk, 0)
+	var cur []codeUnit
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		c, ok := s.chunk(cur, source)
+		cur = cur[:0]
+		if !ok {
+			return
+		}
+		if opt.Dedupe {
+			if seen[c.ID] {
+				return
+			}
+			seen[c.ID] = true
+		}
+		c.Index = len(out)
+		out = append(out, c)
+	}
+	for _, u := range units {
+		for _, piece := range s.fit(u) {
+			if len(cur) > 0 && s.size(cur[0].from, piece.to) > s.target {
+				flush()
+			}
+			cur = append(cur, piece)
+		}
+	}
+	flush()
+	return out
+}
+
+// codeLine is a line of source code.
+type codeLine struct {
+	start, end int // rune offsets, end excluding the newline
+	blank      bool
+	level      int // brace depth or indentation at the start of the line
+	peak       int // deepest level reached on the line
+	endLevel   int // level after the line
+}
+
+// codeUnit is a run of whole lines [from, to) declaring symbol.
+type codeUnit struct {
+	from, to int
+	symbol   string
+}
+
+type codeSplitter struct {
+	r      []rune
+	lines  []codeLine
+	target int
+	exact  bool // units come from a parser; keep their symbols when cutting them
+}
+
+// codeLines splits r into lines and measures their nesting level.
+func codeLines(r []rune, indent bool) []codeLine {
+	var lines []codeLine
+	for start := 0; start < len(r); {
+		end := start
+		for end < len(r) && r[end] != '\n' {
+			end++
+		}
+		blank := true
+		for _, c := range r[start:end] {
+			if !unicode.IsSpace(c) {
+				blank = false
+				break
+			}
+		}
+		lines = append(lines, codeLine{start: start, end: end, blank: blank})
+		start = end + 1
+	}
+	if indent {
+		indentLevels(r, lines)
+	} else {
+		braceLevels(r, lines)
+	}
+	return lines
+}
+
+// braceLevels sets line levels from the depth of (), [] and {} brackets,
+// ignoring those in comments and string literals.
+func braceLevels(r []rune, lines []codeLine) {
+	depth := 0
+	var quote rune // the quote of the string being read, if any
+	blockComment := false
+	for i := range lines {
+		l := &lines[i]
+		l.level, l.peak = depth, depth
+		lineComment := false
+		if quote != '`' {
+			quote = 0 // only raw strings span lines
+		}
+		for j := l.start; j < l.end; j++ {
+			c := r[j]
+			next := rune(0)
+			if j+1 < l.end {
+				next = r[j+1]
+			}
+			switch {
+			case lineComment:
+			case blockComment:
+				if c == '*' && next == '/' {
+					blockComment = false
+					j++
+				}
+			case quote != 0:
+				if c == '\\' {
+					j++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '/' && next == '/':
+				lineComment = true
+			case c == '/' && next == '*':
+				blockComment = true
+				j++
+			case c == '"' || c == '\'' || c == '`':
+				quote = c
+			case c == '{' || c == '(' || c == '[':
+				depth++
+				l.peak = max(l.peak, depth)
+			case c == '}' || c == ')' || c == ']':
+				depth = max(depth-1, 0)
+			}
+		}
+		l.endLevel = depth
+	}
+}
+
+// indentLevels sets line levels from their indentation, counting a tab as
+// four spaces. A blank line takes the level of the line after it.
+func indentLevels(r []rune, lines []codeLine) {
+	next := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := &lines[i]
+		l.endLevel = next
+		if l.blank {
+			l.level, l.peak = next, next
+			continue
+		}
+		width := 0
+		for _, c := range r[l.start:l.end] {
+			if c == '\t' {
+				width += 4
+			} else if c == ' ' {
+				width++
+			} else {
+				break
+			}
+		}
+		l.level, l.peak = width, width
+		next = width
+	}
+}
+
+// reCloser matches lines that end a block, which belong to the unit
+// before them.
+var reCloser = regexp.MustCompile(`^\s*(?:end\b|[)\]}])`)
+
+// cuts returns the lines in [from, to) at which a unit at level starts: a
+// line at that level after a blank line, or after a line that closed a
+// block, unless the line itself closes a block.
+func (s *codeSplitter) cuts(from, to, level int) []int {
+	var out []int
+	prev, blankBefore := -1, false
+	for i := from; i < to; i++ {
+		l := s.lines[i]
+		if l.blank {
+			blankBefore = true
+			continue
+		}
+		if prev >= 0 && l.level == level && !reCloser.MatchString(string(s.r[l.start:l.end])) {
+			p := s.lines[prev]
+			if p.endLevel <= level && (blankBefore || p.peak > level) {
+				out = append(out, i)
+			}
+		}
+		prev, blankBefore = i, false
+	}
+	return out
+}
+
+// split cuts the lines [from, to) into units at the given cut lines.
+func (s *codeSplitter) split(from, to int, cuts []int, parent string) []codeUnit {
+	bounds := append(append([]int{from}, cuts...), to)
+	var out []codeUnit
+	for k := 0; k+1 < len(bounds); k++ {
+		u := codeUnit{from: bounds[k], to: bounds[k+1], symbol: parent}
+		if s.exact {
+			out = append(out, u)
+			continue
+		}
+		if sym := s.symbolAt(u.from, u.to); sym != "" && (k > 0 || parent == "") {
+			u.symbol = sym
+			if parent != "" {
+				u.symbol = parent + "." + sym
+			}
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+// heuristicUnits cuts the file at its top level.
+func (s *codeSplitter) heuristicUnits() []codeUnit {
+	top := -1
+	for _, l := range s.lines {
+		if !l.blank && (top < 0 || l.level < top) {
+			top = l.level
+		}
+	}
+	return s.split(0, len(s.lines), s.cuts(0, len(s.lines), max(top, 0)), "")
+}
+
+// goUnits cuts a Go file at its declarations, with the package clause and
+// imports as the first unit. Comments between declarations go with the
+// declaration after them. It returns nil if the file does not parse.
+func (s *codeSplitter) goUnits(text string) []codeUnit {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", text, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	file := fset.File(f.Package)
+	// lineOf returns the line holding pos; declarations come in order, so
+	// rune offsets are counted on from the last one
+	byteOff, runeOff := 0, 0
+	lineOf := func(pos token.Pos) int {
+		off := file.Offset(pos)
+		runeOff += utf8.RuneCountInString(text[byteOff:off])
+		byteOff = off
+		return sort.Search(len(s.lines), func(i int) bool { return s.lines[i].end >= runeOff })
+	}
+
+	decls := f.Decls
+	header := f.Name.End()
+	for len(decls) > 0 {
+		g, ok := decls[0].(*ast.GenDecl)
+		if !ok || g.Tok != token.IMPORT {
+			break
+		}
+		header = g.End()
+		decls = decls[1:]
+	}
+	units := []codeUnit{{from: 0, to: lineOf(header) + 1, symbol: "package " + f.Name.Name}}
+	for _, d := range decls {
+		from, to := units[len(units)-1].to, lineOf(d.End())+1
+		if to <= from {
+			// several declarations on one line
+			units[len(units)-1].symbol += ", " + goSymbol(d)
+			continue
+		}
+		units = append(units, codeUnit{from: from, to: to, symbol: goSymbol(d)})
+	}
+	units[len(units)-1].to = len(s.lines)
+	s.exact = true
+	return units
+}
+
+// goSymbol names a Go declaration: a function, a method as Type.Method, or
+// the names a type, const or var declaration declares.
+func goSymbol(d ast.Decl) string {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			if recv := receiverName(d.Recv.List[0].Type); recv != "" {
+				return recv + "." + d.Name.Name
+			}
+		}
+		return d.Name.Name
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range spec.Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// size is the number of runes from the start of line from to the end of
+// line to-1.
+func (s *codeSplitter) size(from, to int) int {
+	return s.lines[to-1].end - s.lines[from].start
+}
+
+// fit cuts u until every piece fits in the target size: first between the
+// declarations nested one level deeper, then between lines.
+func (s *codeSplitter) fit(u codeUnit) []codeUnit {
+	if u.to-u.from <= 1 || s.size(u.from, u.to) <= s.target {
+		return []codeUnit{u}
+	}
+	base := s.lines[u.from].level
+	inner := -1
+	for _, l := range s.lines[u.from+1 : u.to] {
+		if !l.blank && l.level > base && (inner < 0 || l.level < inner) {
+			inner = l.level
+		}
+	}
+	if inner >= 0 {
+		if cuts := s.cuts(u.from+1, u.to, inner); len(cuts) > 0 {
+			var out []codeUnit
+			for _, sub := range s.split(u.from, u.to, cuts, u.symbol) {
+				out = append(out, s.fit(sub)...)
+			}
+			return out
+		}
+	}
+	var out []codeUnit
+	start := u.from
+	for i := u.from + 1; i < u.to; i++ {
+		if s.size(start, i+1) > s.target {
+			out = append(out, codeUnit{from: start, to: i, symbol: u.symbol})
+			start = i
+		}
+	}
+	return append(out, codeUnit{from: start, to: u.to, symbol: u.symbol})
+}
+
+// chunk makes a chunk of the units, without the blank lines around them.
+func (s *codeSplitter) chunk(units []codeUnit, source string) (Chunk, bool) {
+	first, last := -1, -1
+	for i := units[0].from; i < units[len(units)-1].to; i++ {
+		if !s.lines[i].blank {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return Chunk{}, false
+	}
+	start := s.lines[first].start
+	end := s.lines[last].end
+	for end > start && unicode.IsSpace(s.r[end-1]) {
+		end--
+	}
+
+	var symbols []string
+	seen := map[string]bool{}
+	for _, u := range units {
+		if u.symbol != "" && !seen[u.symbol] {
+			seen[u.symbol] = true
+			symbols = append(symbols, u.symbol)
+		}
+	}
+	text := string(s.r[start:end])
+	return Chunk{
+		ID:        ID(source, text),
+		Text:      text,
+		Start:     start,
+		End:       end,
+		Source:    source,
+		Symbol:    strings.Join(symbols, ", "),
+		StartLine: first + 1,
+		EndLine:   last + 1,
+	}, true
+}
+
+var (
+	// reDeclaration matches declarations introduced by a keyword.
+	reDeclaration = regexp.MustCompile(`\b(?:func|function|def|class|struct|interface|enum|trait|impl|fn|module|object|record|namespace|protocol|extension|union|type)\s+([A-Za-z_$][\w$]*)`)
+	// reBinding matches `const name = ...` and the like.
+	reBinding = regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*[:=]`)
+	// reMethod matches C-style function and method signatures.
+	reMethod = regexp.MustCompile(`^\s*(?:[\w<>\[\],.?*&]+\s+)+\*?([A-Za-z_]\w*)\s*\(`)
+	// notDeclaration are statements that reMethod would take for signatures.
+	notDeclaration = regexp.MustCompile(`^\s*(?:return|throw|new|else|elif|case|await|yield|delete|goto|if|for|while|switch|catch|do)\b`)
+)
+
+// symbolAt guesses the symbol declared by the first lines of [from, to),
+// looking past comments and annotations.
+func (s *codeSplitter) symbolAt(from, to int) string {
+	for i, n := from, 0; i < to && n < 5; i++ {
+		l := s.lines[i]
+		if l.blank {
+			continue
+		}
+		n++
+		line := string(s.r[l.start:l.end])
+		for _, re := range []*regexp.Regexp{reDeclaration, reBinding} {
+			if m := re.FindStringSubmatch(line); m != nil {
+				return m[1]
+			}
+		}
+		if m := reMethod.FindStringSubmatch(line); m != nil && !notDeclaration.MatchString(line) {
+			return m[1]
+		}
+		if strings.ContainsAny(line, "{:;") {
+			// the first statement holds no declaration
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package chunk
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const sampleGo = `package store
+
+import "errors"
+
+// ErrMissing is returned for unknown keys.
+var ErrMissing = errors.New("missing")
+
+type Store struct {
+	m map[string]string
+}
+
+// Get returns the value of key.
+func (s *Store) Get(key string) (string, error) {
+	v, ok := s.m[key]
+	if !ok {
+		return "", ErrMissing
+	}
+	return v, nil
+}
+
+func New() *Store { return &Store{m: map[string]string{}} }
+`
+
+// symbols lists "symbol lines" for each chunk.
+func symbols(chunks []Chunk) string {
+	var out []string
+	for _, c := range chunks {
+		out = append(out, fmt.Sprintf("%s %d-%d", c.Symbol, c.StartLine, c.EndLine))
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestSplitCodeGoDeclarations(t *testing.T) {
+	chunks := SplitCode(sampleGo, "src", "go", Options{TargetRunes: 160})
+	want := "package store, ErrMissing, Store 1-10\n" +
+		"Store.Get 12-19\n" +
+		"New 21-21"
+	if got := symbols(chunks); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	get := chunks[1]
+	if !strings.HasPrefix(get.Text, "// Get returns") || !strings.HasSuffix(get.Text, "return v, nil\n}") {
+		t.Fatalf("method chunk should keep its doc comment and body verbatim: %q", get.Text)
+	}
+	r := []rune(sampleGo)
+	for _, c := range chunks {
+		if string(r[c.Start:c.End]) != c.Text {
+			t.Fatalf("chunk %d offsets %d-%d do not match its text", c.Index, c.Start, c.End)
+		}
+	}
+
+	// with room to spare, neighbouring declarations share a chunk
+	chunks = SplitCode(sampleGo, "src", "go", Options{TargetRunes: 900})
+	if len(chunks) != 1 || chunks[0].Symbol != "package store, ErrMissing, Store, Store.Get, New" {
+		t.Fatalf("expected one chunk naming every declaration, got %s", symbols(chunks))
+	}
+}
+
+func TestSplitCodeHeuristics(t *testing.T) {
+	java := `package x;
+
+/** A counter. */
+public class Counter {
+  private int n;
+
+  @Override
+  public String toString() {
+    return "}" + n;
+  }
+
+  public int get() {
+    return n;
+  }
+}
+`
+	want := "Counter 1-5\nCounter.toString 7-10\nCounter.get 12-15"
+	if got := symbols(SplitCode(java, "src", "java", Options{TargetRunes: 80})); got != want {
+		t.Fatalf("java: got:\n%s\nwant:\n%s", got, want)
+	}
+
+	py := `import os
+
+@cache
+def load(path):
+    return open(path).read()
+
+
+class Index:
+    def add(self, doc):
+        pass
+
+    def search(self, q):
+        pass
+`
+	want = "load 1-5\nIndex 8-10\nIndex.search 12-13"
+	if got := symbols(SplitCode(py, "src", "python", Options{TargetRunes: 70})); got != want {
+		t.Fatalf("python: got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSplitCodeLongDeclaration(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("package p\n\nfunc Long() {\n")
+	for i := 0; i < 40; i++ {
+		b.WriteString("\tx := compute()\n")
+	}
+	b.WriteString("}\n")
+	chunks := SplitCode(b.String(), "src", "go", Options{TargetRunes: 200})
+	if len(chunks) < 3 {
+		t.Fatalf("expected the function to be split, got %d chunks", len(chunks))
+	}
+	for _, c := range chunks[1:] {
+		if c.Symbol != "Long" {
+			t.Fatalf("pieces of a function should keep its name, got %q", c.Symbol)
+		}
+		if n := len([]rune(c.Text)); n > 200 {
+			t.Fatalf("chunk of %d runes exceeds the target", n)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package chunk
+
+import (
+	"strings"
+	"unicode"
+)
+
+// block is a unit of a markdown document for SplitMarkdown: a heading, a
+// paragraph, or a fenced code block / table that must not be split.
+type block struct {
+	start, end int // rune offsets into the original text
+	size       int // rune count after whitespace normalization
+	level      int // heading level (1-6), 0 for non-headings
+	atomic     bool
+}
+
+// SplitMarkdown chunks markdown along its heading hierarchy. Sections are
+// never merged across headings; each chunk carries the path of headings above
+// it in Chunk.Heading. Fenced code blocks and tables are kept whole, even when
+// that makes a chunk longer than TargetRunes; long paragraphs fall back to
+// SplitStructure. When opt.PrependHeading is set the heading path is prepended
+// to Chunk.Text, which is what gets embedded.
+func SplitMarkdown(text, source string, opt Options) []Chunk {
+	if opt.TargetRunes <= 0 {
+		opt = DefaultOptions()
+	}
+	r := []rune(text)
+	blocks := markdownBlocks(r)
+
+	seen := map[string]bool{}
+	out := make([]Chunk, 0)
+	emit := func(c Chunk, path []string) {
+		c.Heading = headingPath(path)
+		if opt.PrependHeading && c.Heading != "" {
+			c.Text = c.Heading + "\n\n" + c.Text
+		}
+		c.ID = ID(source, c.Text)
+		if opt.Dedupe {
+			if seen[c.ID] {
+				return
+			}
+			seen[c.ID] = true
+		}
+		c.Index = len(out)
+		out = append(out, c)
+	}
+
+	var path []string
+	var cur []block
+	curSize := 0
+	flush := func() {
+		if len(cur) > 0 {
+			start, end := cur[0].start, cur[len(cur)-1].end
+			norm, _ := normalizeWS(string(r[start:end]))
+			if cur[0].atomic && len(cur) == 1 {
+				// keep code and tables verbatim
+				norm = []rune(strings.TrimSpace(string(r[start:end])))
+			}
+			emit(Chunk{Text: string(norm), Start: start, End: end, Source: source}, path)
+		}
+		cur, curSize = cur[:0], 0
+	}
+
+	for _, b := range blocks {
+		if b.level > 0 {
+			flush()
+			if b.level-1 < len(path) {
+				path = path[:b.level-1]
+			}
+			for len(path) < b.level-1 {
+				path = append(path, "")
+			}
+			path = append(path, headingTitle(r[b.start:b.end]))
+			continue
+		}
+		if len(cur) > 0 && (b.atomic || cur[0].atomic || curSize+b.size > opt.TargetRunes) {
+			flush()
+		}
+		if !b.atomic && b.size > opt.TargetRunes {
+			long := opt
+			long.Dedupe = false
+			for _, c := range SplitStructure(string(r[b.start:b.end]), source, long) {
+				c.Start += b.start
+				c.End += b.start
+				emit(c, path)
+			}
+			continue
+		}
+		cur = append(cur, b)
+		curSize += b.size + 1
+	}
+	flush()
+	return out
+}
+
+// markdownBlocks splits r into headings, paragraphs, fenced code blocks and tables.
+func markdownBlocks(r []rune) []block {
+	var blocks []block
+	add := func(lo, hi, level int, atomic bool) {
+		lo, hi = trimRange(r, lo, hi)
+		if lo < hi {
+			blocks = append(blocks, block{start: lo, end: hi, size: normSize(r[lo:hi]), level: level, atomic: atomic})
+		}
+	}
+
+	paraStart, paraEnd := -1, -1
+	tableStart, tableEnd := -1, -1
+	fence, fenceStart := "", -1
+	flushPara := func() {
+		if paraStart >= 0 {
+			add(paraStart, paraEnd, 0, false)
+		}
+		paraStart = -1
+	}
+	flushTable := func() {
+		if tableStart >= 0 {
+			add(tableStart, tableEnd, 0, true)
+		}
+		tableStart = -1
+	}
+
+	for lineStart := 0; lineStart <= len(r); {
+		lineEnd := lineStart
+		for lineEnd < len(r) && r[lineEnd] != '\n' {
+			lineEnd++
+		}
+		lo, hi := trimRange(r, lineStart, lineEnd)
+		line := string(r[lo:hi])
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+				add(fenceStart, hi, 0, true)
+				fence = ""
+			}
+		case strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~"):
+			flushPara()
+			flushTable()
+			fence = line[:3]
+			fenceStart = lo
+		case lo == hi:
+			flushPara()
+			flushTable()
+		case isHeading(r[lo:hi]):
+			flushPara()
+			flushTable()
+			add(lo, hi, strings.IndexFunc(line, func(c rune) bool { return c != '#' }), false)
+		case strings.HasPrefix(line, "|"):
+			flushPara()
+			if tableStart < 0 {
+				tableStart = lo
+			}
+			tableEnd = hi
+		default:
+			flushTable()
+			if paraStart < 0 {
+				paraStart = lo
+			}
+			paraEnd = hi
+		}
+		lineStart = lineEnd + 1
+	}
+	if fence != "" {
+		// unterminated fence: keep the rest of the document as code
+		add(fenceStart, len(r), 0, true)
+	}
+	flushPara()
+	flushTable()
+	return blocks
+}
+
+// headingPath joins the non-empty titles of path into a breadcrumb.
+func headingPath(path []string) string {
+	parts := make([]string, 0, len(path))
+	for _, p := range path {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, HeadingSeparator)
+}
+
+// headingTitle strips the leading hashes and any closing hashes from a heading line.
+func headingTitle(line []rune) string {
+	s := strings.TrimLeft(string(line), "#")
+	s = strings.TrimRightFunc(s, unicode.IsSpace)
+	s = strings.TrimRight(s, "#")
+	return strings.TrimSpace(s)
+}
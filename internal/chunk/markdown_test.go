@@ -0,0 +1,82 @@
+package chunk
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleMarkdown = `# Install
+
+Download the release archive.
+
+## Linux
+
+Unpack it into /opt.
+
+### Troubleshooting
+
+If the binary does not start, check permissions.
+
+` + "```sh\nchmod +x ocnlp\n\n./ocnlp server\n```" + `
+
+| flag | meaning |
+|------|---------|
+| -v   | verbose |
+
+## Windows
+
+Run the installer.
+`
+
+func TestSplitMarkdownHeadingPath(t *testing.T) {
+	opt := Options{Strategy: StrategyMarkdown, TargetRunes: 200, OverlapRunes: 0, MinRunes: 1}
+	chunks := Split(sampleMarkdown, "src", opt)
+
+	var got []string
+	for _, c := range chunks {
+		got = append(got, c.Heading+": "+strings.SplitN(c.Text, "\n", 2)[0])
+	}
+	want := []string{
+		"Install: Download the release archive.",
+		"Install > Linux: Unpack it into /opt.",
+		"Install > Linux > Troubleshooting: If the binary does not start, check permissions.",
+		"Install > Linux > Troubleshooting: ```sh",
+		"Install > Linux > Troubleshooting: | flag | meaning |",
+		"Install > Windows: Run the installer.",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSplitMarkdownKeepsCodeBlocksIntact(t *testing.T) {
+	opt := Options{Strategy: StrategyMarkdown, TargetRunes: 10, OverlapRunes: 0, MinRunes: 1}
+	chunks := Split(sampleMarkdown, "src", opt)
+	var code, table string
+	for _, c := range chunks {
+		if strings.HasPrefix(c.Text, "```") {
+			code = c.Text
+		}
+		if strings.HasPrefix(c.Text, "|") {
+			table = c.Text
+		}
+	}
+	if code != "```sh\nchmod +x ocnlp\n\n./ocnlp server\n```" {
+		t.Fatalf("code block not kept verbatim: %q", code)
+	}
+	if strings.Count(table, "\n") != 2 {
+		t.Fatalf("table not kept whole: %q", table)
+	}
+}
+
+func TestSplitMarkdownPrependHeading(t *testing.T) {
+	opt := Options{Strategy: StrategyMarkdown, TargetRunes: 200, MinRunes: 1, PrependHeading: true}
+	chunks := Split(sampleMarkdown, "src", opt)
+	last := chunks[len(chunks)-1]
+	if last.Text != "Install > Windows\n\nRun the installer." {
+		t.Fatalf("unexpected text %q", last.Text)
+	}
+	if got := string([]rune(sampleMarkdown)[last.Start:last.End]); got != "Run the installer." {
+		t.Fatalf("offsets should point at the body, got %q", got)
+	}
+}
@@ -0,0 +1,243 @@
+package chunk
+
+import (
+	"strings"
+	"unicode"
+)
+
+// unit is an indivisible piece of text for SplitStructure: a sentence or a
+// markdown heading line.
+type unit struct {
+	start, end int  // rune offsets into the original text
+	size       int  // rune count after whitespace normalization
+	para       bool // first unit of a paragraph
+	heading    bool // a markdown heading line
+}
+
+// abbreviations lists lowercase words that are commonly followed by a period
+// without ending a sentence.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true, "st": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "cf": true, "al": true, "approx": true,
+	"fig": true, "figs": true, "eq": true, "eqs": true, "no": true, "nos": true, "vol": true,
+	"ch": true, "sec": true, "p": true, "pp": true, "inc": true, "ltd": true, "co": true,
+	"corp": true, "dept": true, "est": true, "min": true, "max": true,
+}
+
+// SplitStructure chunks text along its structure. Paragraphs (blank-line
+// separated), sentences and markdown headings are found in the original text,
+// then packed greedily into chunks of up to TargetRunes. A chunk is closed
+// early at a paragraph when the whole next paragraph would not fit, and always
+// before a heading once it holds MinRunes. Overlap is sentence-granular and is
+// only carried when a paragraph has to be split. Sentences longer than
+// TargetRunes fall back to SplitRunes.
+func SplitStructure(text, source string, opt Options) []Chunk {
+	if opt.TargetRunes <= 0 {
+		opt = DefaultOptions()
+	}
+	r := []rune(text)
+	units := structureUnits(r)
+	if len(units) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	out := make([]Chunk, 0)
+	emit := func(c Chunk) {
+		if opt.Dedupe {
+			if seen[c.ID] {
+				return
+			}
+			seen[c.ID] = true
+		}
+		c.Index = len(out)
+		out = append(out, c)
+	}
+
+	var cur []unit
+	curSize, fresh := 0, 0
+	flush := func(overlap int) {
+		if fresh > 0 {
+			start, end := cur[0].start, cur[len(cur)-1].end
+			norm, _ := normalizeWS(string(r[start:end]))
+			t := string(norm)
+			emit(Chunk{ID: ID(source, t), Text: t, Start: start, End: end, Source: source})
+		}
+		// carry trailing sentences that fit in the overlap budget
+		keep, size := 0, 0
+		for i := len(cur) - 1; i > 0; i-- {
+			if size+cur[i].size > overlap || cur[i].heading {
+				break
+			}
+			size += cur[i].size + 1
+			keep++
+		}
+		cur = append(cur[:0], cur[len(cur)-keep:]...)
+		curSize = 0
+		for _, u := range cur {
+			curSize += u.size + 1
+		}
+		fresh = 0
+	}
+
+	for i, u := range units {
+		if len(cur) > 0 {
+			switch {
+			case u.heading && curSize >= opt.MinRunes:
+				flush(0)
+			case u.para && curSize >= opt.MinRunes && curSize+paragraphSize(units, i) > opt.TargetRunes:
+				flush(0)
+			case curSize+u.size > opt.TargetRunes:
+				if u.para {
+					flush(0)
+				} else {
+					flush(min(opt.OverlapRunes, opt.TargetRunes-u.size-1))
+				}
+			}
+		}
+		if u.size > opt.TargetRunes {
+			flush(0)
+			long := opt
+			long.Dedupe = false
+			for _, c := range SplitRunes(string(r[u.start:u.end]), source, long) {
+				c.Start += u.start
+				c.End += u.start
+				emit(c)
+			}
+			continue
+		}
+		cur = append(cur, u)
+		curSize += u.size + 1
+		fresh++
+	}
+	flush(0)
+	return out
+}
+
+// paragraphSize returns the normalized size of the paragraph starting at units[i].
+func paragraphSize(units []unit, i int) int {
+	size := 0
+	for j := i; j < len(units); j++ {
+		if j > i && units[j].para {
+			break
+		}
+		size += units[j].size + 1
+	}
+	return size
+}
+
+// structureUnits splits r into headings and sentences, marking paragraph starts.
+func structureUnits(r []rune) []unit {
+	var units []unit
+	paraStart, paraEnd := -1, -1
+	flushPara := func() {
+		if paraStart >= 0 {
+			units = append(units, sentences(r, paraStart, paraEnd)...)
+		}
+		paraStart, paraEnd = -1, -1
+	}
+
+	for lineStart := 0; lineStart < len(r); {
+		lineEnd := lineStart
+		for lineEnd < len(r) && r[lineEnd] != '\n' {
+			lineEnd++
+		}
+		lo, hi := trimRange(r, lineStart, lineEnd)
+		switch {
+		case lo == hi:
+			flushPara()
+		case isHeading(r[lo:hi]):
+			flushPara()
+			units = append(units, unit{start: lo, end: hi, size: normSize(r[lo:hi]), para: true, heading: true})
+		default:
+			if paraStart < 0 {
+				paraStart = lo
+			}
+			paraEnd = hi
+		}
+		lineStart = lineEnd + 1
+	}
+	flushPara()
+	return units
+}
+
+// sentences splits r[start:end] into sentence units.
+func sentences(r []rune, start, end int) []unit {
+	var out []unit
+	add := func(lo, hi int) {
+		lo, hi = trimRange(r, lo, hi)
+		if lo < hi {
+			out = append(out, unit{start: lo, end: hi, size: normSize(r[lo:hi]), para: len(out) == 0})
+		}
+	}
+	from := start
+	for i := start; i < end; i++ {
+		if !strings.ContainsRune(".!?…", r[i]) {
+			continue
+		}
+		j := i + 1
+		for j < end && strings.ContainsRune(".!?…\"')]”’", r[j]) {
+			j++
+		}
+		if j < end && !unicode.IsSpace(r[j]) {
+			continue // e.g. 3.14, example.com
+		}
+		next := j
+		for next < end && unicode.IsSpace(r[next]) {
+			next++
+		}
+		if next < end {
+			if unicode.IsLower(r[next]) {
+				continue
+			}
+			if r[i] == '.' && isAbbreviation(r[from:i]) {
+				continue
+			}
+		}
+		add(from, j)
+		from = j
+		i = j - 1
+	}
+	add(from, end)
+	return out
+}
+
+// isAbbreviation reports whether the word ending s (just before a period) is a
+// known abbreviation or a single-letter initial.
+func isAbbreviation(s []rune) bool {
+	i := len(s)
+	for i > 0 && (unicode.IsLetter(s[i-1]) || s[i-1] == '.') {
+		i--
+	}
+	w := strings.ToLower(string(s[i:]))
+	if w == "" {
+		return false
+	}
+	if len([]rune(w)) == 1 {
+		return true
+	}
+	return abbreviations[w]
+}
+
+func isHeading(line []rune) bool {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	return n >= 1 && n <= 6 && n < len(line) && unicode.IsSpace(line[n])
+}
+
+func trimRange(r []rune, lo, hi int) (int, int) {
+	for lo < hi && unicode.IsSpace(r[lo]) {
+		lo++
+	}
+	for hi > lo && unicode.IsSpace(r[hi-1]) {
+		hi--
+	}
+	return lo, hi
+}
+
+func normSize(r []rune) int {
+	n, _ := normalizeWS(string(r))
+	return len(n)
+}
@@ -0,0 +1,96 @@
+package chunk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSentencesAbbreviations(t *testing.T) {
+	text := "Dr. Smith measured 3.14 units, e.g. in Fig. 2. The value was J. R. R. approved! Was it? Yes."
+	r := []rune(text)
+	var got []string
+	for _, u := range sentences(r, 0, len(r)) {
+		got = append(got, string(r[u.start:u.end]))
+	}
+	want := []string{
+		"Dr. Smith measured 3.14 units, e.g. in Fig. 2.",
+		"The value was J. R. R. approved!",
+		"Was it?",
+		"Yes.",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestSplitStructureKeepsSentencesWhole(t *testing.T) {
+	text := "The pump must be primed before use. Check the seal for leaks. " +
+		"Replace the filter every month. Never run the pump dry."
+	opt := Options{Strategy: StrategyStructure, TargetRunes: 70, OverlapRunes: 35, MinRunes: 10}
+	chunks := Split(text, "src", opt)
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(chunks))
+	}
+	for _, c := range chunks {
+		if !strings.HasSuffix(c.Text, ".") {
+			t.Fatalf("chunk cuts a sentence: %q", c.Text)
+		}
+		if n := len([]rune(c.Text)); n > opt.TargetRunes {
+			t.Fatalf("chunk longer than target (%d): %q", n, c.Text)
+		}
+	}
+	if !strings.Contains(chunks[1].Text, "Check the seal for leaks.") {
+		t.Fatalf("expected sentence overlap in %q", chunks[1].Text)
+	}
+}
+
+func TestSplitStructureParagraphsAndHeadings(t *testing.T) {
+	text := "# Install\n\nDownload the archive and unpack it.\nRun the installer.\n\n" +
+		"## Linux\n\nUse the package manager instead.\n\nThis is the second paragraph here."
+	opt := Options{Strategy: StrategyStructure, TargetRunes: 80, OverlapRunes: 20, MinRunes: 10}
+	chunks := Split(text, "src", opt)
+	var texts []string
+	for _, c := range chunks {
+		texts = append(texts, c.Text)
+		if got, _ := normalizeWS(string([]rune(text)[c.Start:c.End])); string(got) != c.Text {
+			t.Fatalf("offsets do not match chunk text %q", c.Text)
+		}
+	}
+	want := []string{
+		"# Install Download the archive and unpack it. Run the installer.",
+		"## Linux Use the package manager instead. This is the second paragraph here.",
+	}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q\nwant %q", texts, want)
+	}
+}
+
+func TestSplitStructureLongSentenceFallsBack(t *testing.T) {
+	text := strings.Repeat("word ", 60) + "end."
+	opt := Options{Strategy: StrategyStructure, TargetRunes: 50, OverlapRunes: 10, MinRunes: 5}
+	chunks := Split(text, "src", opt)
+	if len(chunks) < 5 {
+		t.Fatalf("expected long sentence to be split, got %d chunks", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Fatalf("chunk %d has index %d", i, c.Index)
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := DefaultOptions().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := DefaultOptions()
+	bad.Strategy = "words"
+	if err := bad.Validate(); err == nil {
+		t.Fatal("expected unknown strategy error")
+	}
+	bad = DefaultOptions()
+	bad.OverlapRunes = bad.TargetRunes
+	if err := bad.Validate(); err == nil {
+		t.Fatal("expected overlap error")
+	}
+}
@@ -5,21 +5,38 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/ollama/ollama/api"
+
+	"github.com/winzerprince/oc-nlp/internal/retry"
 )
 
 // Config holds configuration for Ollama embeddings
 type Config struct {
-	Host  string // e.g., "http://localhost:11434"
-	Model string // e.g., "nomic-embed-text"
+	Host        string // e.g., "http://localhost:11434"
+	Model       string // e.g., "nomic-embed-text"
+	BatchSize   int    // texts per embed request in EmbedBatch (default 32)
+	Concurrency int    // embed requests in flight in EmbedBatch (default 4)
+	// Retry controls retries and the per-request timeout; the zero value
+	// means the defaults from DefaultConfig.
+	Retry retry.Policy
 }
 
 // DefaultConfig returns a default Ollama configuration
 func DefaultConfig() Config {
 	return Config{
-		Host:  "http://localhost:11434",
-		Model: "nomic-embed-text",
+		Host:        "http://localhost:11434",
+		Model:       "nomic-embed-text",
+		BatchSize:   32,
+		Concurrency: 4,
+		Retry: retry.Policy{
+			MaxAttempts: 4,
+			BaseDelay:   500 * time.Millisecond,
+			MaxDelay:    10 * time.Second,
+			Timeout:     2 * time.Minute,
+		},
 	}
 }
 
@@ -55,12 +72,31 @@ func NewClient(cfg Config) (*Client, error) {
 
 // Embed generates an embedding vector for the given text
 func (c *Client) Embed(ctx context.Context, text string) ([]float64, error) {
+	embs, err := c.embed(ctx, text, 1)
+	if err != nil {
+		return nil, err
+	}
+	return embs[0], nil
+}
+
+// embed sends a single embed request; input is a string or a []string holding
+// want texts.
+func (c *Client) embed(ctx context.Context, input any, want int) ([][]float64, error) {
 	req := &api.EmbedRequest{
 		Model: c.cfg.Model,
-		Input: text,
+		Input: input,
 	}
 	
-	resp, err := c.client.Embed(ctx, req)
+	policy := c.cfg.Retry
+	if policy == (retry.Policy{}) {
+		policy = DefaultConfig().Retry
+	}
+	var resp *api.EmbedResponse
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.client.Embed(ctx, req)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("ollama embed: %w", err)
 	}
@@ -68,28 +104,88 @@ func (c *Client) Embed(ctx context.Context, text string) ([]float64, error) {
 	if len(resp.Embeddings) == 0 {
 		return nil, fmt.Errorf("no embeddings returned")
 	}
+	if len(resp.Embeddings) != want {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", want, len(resp.Embeddings))
+	}
 	
 	// Convert []float32 to []float64
-	embedding32 := resp.Embeddings[0]
-	embedding64 := make([]float64, len(embedding32))
-	for i, v := range embedding32 {
-		embedding64[i] = float64(v)
+	out := make([][]float64, len(resp.Embeddings))
+	for i, embedding32 := range resp.Embeddings {
+		embedding64 := make([]float64, len(embedding32))
+		for j, v := range embedding32 {
+			embedding64[j] = float64(v)
+		}
+		out[i] = embedding64
 	}
 	
-	return embedding64, nil
+	return out, nil
 }
 
-// EmbedBatch generates embeddings for multiple texts
+// EmbedBatch generates embeddings for multiple texts. Texts are sent in
+// requests of Config.BatchSize inputs, with up to Config.Concurrency requests
+// in flight. The result is in the same order as texts; the first failing
+// request cancels the rest.
 func (c *Client) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
 	embeddings := make([][]float64, len(texts))
-	
-	for i, text := range texts {
-		emb, err := c.Embed(ctx, text)
-		if err != nil {
-			return nil, fmt.Errorf("embed text %d: %w", i, err)
+	if len(texts) == 0 {
+		return embeddings, nil
+	}
+
+	size := c.cfg.BatchSize
+	if size <= 0 {
+		size = DefaultConfig().BatchSize
+	}
+	workers := c.cfg.Concurrency
+	if workers <= 0 {
+		workers = DefaultConfig().Concurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	starts := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range starts {
+				end := min(start+size, len(texts))
+				embs, err := c.embed(ctx, texts[start:end], end-start)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("embed texts %d-%d: %w", start, end-1, err)
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				copy(embeddings[start:end], embs)
+			}
+		}()
+	}
+
+feed:
+	for start := 0; start < len(texts); start += size {
+		select {
+		case starts <- start:
+		case <-ctx.Done():
+			break feed
 		}
-		embeddings[i] = emb
 	}
-	
+	close(starts)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return embeddings, nil
 }
@@ -0,0 +1,94 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// embedServer answers /api/embed with one-dimensional vectors holding the
+// length of each input, using handle to override responses.
+func embedServer(t *testing.T, requests *atomic.Int64, handle func(inputs []string) int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req struct {
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if handle != nil {
+			if code := handle(req.Input); code != http.StatusOK {
+				w.WriteHeader(code)
+				fmt.Fprint(w, `{"error":"boom"}`)
+				return
+			}
+		}
+		out := make([][]float32, len(req.Input))
+		for i, s := range req.Input {
+			out[i] = []float32{float32(len(s))}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"embeddings": out})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEmbedBatchPreservesOrder(t *testing.T) {
+	var requests atomic.Int64
+	srv := embedServer(t, &requests, nil)
+	c, err := NewClient(Config{Host: srv.URL, Model: "m", BatchSize: 3, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := make([]string, 10)
+	for i := range texts {
+		texts[i] = strings.Repeat("x", i+1)
+	}
+	embs, err := c.EmbedBatch(context.Background(), texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embs) != len(texts) {
+		t.Fatalf("got %d embeddings, want %d", len(embs), len(texts))
+	}
+	for i, e := range embs {
+		if e[0] != float64(i+1) {
+			t.Fatalf("embedding %d out of order: %v", i, e)
+		}
+	}
+	if got := requests.Load(); got != 4 {
+		t.Fatalf("expected 4 batched requests, got %d", got)
+	}
+}
+
+func TestEmbedBatchError(t *testing.T) {
+	var requests atomic.Int64
+	srv := embedServer(t, &requests, func(inputs []string) int {
+		for _, s := range inputs {
+			if s == "bad" {
+				return http.StatusBadRequest
+			}
+		}
+		return http.StatusOK
+	})
+	c, err := NewClient(Config{Host: srv.URL, Model: "m", BatchSize: 2, Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.EmbedBatch(context.Background(), []string{"a", "b", "c", "bad", "e"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "embed texts 2-3") {
+		t.Fatalf("error should name the failing batch, got %v", err)
+	}
+}
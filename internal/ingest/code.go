@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// codeLanguages maps the extensions of source files to their language.
+var codeLanguages = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".rb":    "ruby",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".java":  "java",
+	".kt":    "kotlin",
+	".scala": "scala",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".rs":    "rust",
+	".swift": "swift",
+	".php":   "php",
+	".sh":    "shell",
+	".bash":  "shell",
+	".sql":   "sql",
+	".proto": "protobuf",
+}
+
+// MaxCodeBytes is the size above which source files are skipped; they are
+// usually generated or minified.
+const MaxCodeBytes = 1 << 20
+
+// CodeLanguage returns the language of a source file from its extension,
+// or "" if it is not a recognised source file.
+func CodeLanguage(path string) string {
+	return codeLanguages[strings.ToLower(filepath.Ext(path))]
+}
+
+// extractCode reads a source file verbatim, recording its language in the
+// metadata. Files that are too large or not UTF-8 are unsupported.
+func extractCode(path, language string) (*Extracted, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxCodeBytes || !utf8.Valid(b) {
+		return nil, ErrUnsupported
+	}
+	e := extracted("code", string(b), nil)
+	e.Meta = map[string]string{"language": language}
+	return e, nil
+}
@@ -0,0 +1,208 @@
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// extractDOCX reads the body of a Word document (word/document.xml), taking
+// heading levels from its paragraph styles, and its title and author from
+// docProps/core.xml. Headers, footers, comments and deleted revisions are
+// left out.
+func extractDOCX(zr *zip.Reader) (string, map[string]string, error) {
+	levels, err := docxHeadingLevels(zr)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := openEntry(zr, "word/document.xml")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	var (
+		w      docWriter
+		tables docTables
+		paras  []*docxParagraph // nested when a text box sits in a paragraph
+		inText bool
+	)
+	d := xml.NewDecoder(f)
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var p *docxParagraph
+			if len(paras) > 0 {
+				p = paras[len(paras)-1]
+			}
+			switch t.Name.Local {
+			case "Fallback":
+				// the same content as the preceding mc:Choice
+				if err := d.Skip(); err != nil {
+					return "", nil, err
+				}
+			case "p":
+				paras = append(paras, &docxParagraph{level: -1, outline: -1})
+			case "pStyle":
+				if p != nil {
+					p.style = attr(t, "val")
+				}
+			case "outlineLvl":
+				if p != nil {
+					p.outline = atoiOr(attr(t, "val"), -1)
+				}
+			case "numPr":
+				if p != nil && p.level < 0 {
+					p.level = 0
+				}
+			case "ilvl":
+				if p != nil {
+					p.level = atoiOr(attr(t, "val"), 0)
+				}
+			case "t":
+				inText = true
+			case "tab":
+				// run tabs; tab stops in paragraph properties have a val
+				if p != nil && attr(t, "val") == "" {
+					p.text.WriteString("\t")
+				}
+			case "br", "cr":
+				if p != nil {
+					p.text.WriteString("\n")
+				}
+			case "tbl":
+				tables.open()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if len(paras) == 0 {
+					continue
+				}
+				p := paras[len(paras)-1]
+				paras = paras[:len(paras)-1]
+				text := strings.TrimSpace(p.text.String())
+				switch {
+				case tables.inside():
+					tables.text(text)
+				case p.outline >= 0 && p.outline < 9:
+					w.heading(p.outline+1, text)
+				case levels[p.style] > 0:
+					w.heading(levels[p.style], text)
+				case p.level >= 0:
+					w.listItem(p.level, text)
+				default:
+					w.block(text, "")
+				}
+			case "tc":
+				if tables.inside() {
+					tables.endCell()
+				}
+			case "tr":
+				if tables.inside() {
+					tables.endRow(&w)
+				}
+			case "tbl":
+				tables.close()
+			}
+		case xml.CharData:
+			if inText && len(paras) > 0 {
+				paras[len(paras)-1].text.Write(t)
+			}
+		}
+	}
+
+	var core struct {
+		Title   string `xml:"title"`
+		Creator string `xml:"creator"`
+	}
+	if err := readXML(zr, "docProps/core.xml", &core); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, err
+	}
+	return w.String(), docMeta(core.Title, core.Creator), nil
+}
+
+// docxParagraph is a paragraph being read.
+type docxParagraph struct {
+	text    strings.Builder
+	style   string
+	outline int // outline level set on the paragraph itself, or -1
+	level   int // list level, or -1 if the paragraph is not a list item
+}
+
+var reHeadingStyle = regexp.MustCompile(`^heading ?([1-9])$`)
+
+// docxHeadingLevels maps the IDs of the heading styles in word/styles.xml to
+// their level, from 1. A style is a heading if it has an outline level, is
+// named "heading N" or "Title", or is based on such a style.
+func docxHeadingLevels(zr *zip.Reader) (map[string]int, error) {
+	var styles struct {
+		Styles []struct {
+			ID      string  `xml:"styleId,attr"`
+			Name    docxVal `xml:"name"`
+			BasedOn docxVal `xml:"basedOn"`
+			PPr     struct {
+				OutlineLvl *docxVal `xml:"outlineLvl"`
+			} `xml:"pPr"`
+		} `xml:"style"`
+	}
+	if err := readXML(zr, "word/styles.xml", &styles); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	own := map[string]int{}
+	basedOn := map[string]string{}
+	for _, s := range styles.Styles {
+		name := strings.ToLower(s.Name.Val)
+		switch {
+		case s.PPr.OutlineLvl != nil:
+			if lvl := atoiOr(s.PPr.OutlineLvl.Val, 9); lvl < 9 {
+				own[s.ID] = lvl + 1
+			}
+		case name == "title":
+			own[s.ID] = 1
+		case reHeadingStyle.MatchString(name):
+			own[s.ID] = atoiOr(reHeadingStyle.FindStringSubmatch(name)[1], 0)
+		}
+		basedOn[s.ID] = s.BasedOn.Val
+	}
+
+	levels := map[string]int{}
+	for id := range basedOn {
+		// follow a few steps of inheritance, guarding against cycles
+		for s, n := id, 0; s != "" && n < 10; s, n = basedOn[s], n+1 {
+			if lvl, ok := own[s]; ok {
+				levels[id] = lvl
+				break
+			}
+		}
+	}
+	return levels, nil
+}
+
+// docxVal is an element whose value is in its w:val attribute.
+type docxVal struct {
+	Val string `xml:"val,attr"`
+}
+
+func atoiOr(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// extractEPUB reads the chapters of an EPUB book in spine (reading) order,
+// extracting each with extractHTML, and its title and authors from the
+// package document.
+func extractEPUB(zr *zip.Reader) (string, map[string]string, error) {
+	var container struct {
+		Rootfiles []struct {
+			FullPath  string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := readXML(zr, "META-INF/container.xml", &container); err != nil {
+		return "", nil, err
+	}
+	opfPath := ""
+	for _, rf := range container.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			opfPath = rf.FullPath
+			break
+		}
+	}
+	if opfPath == "" {
+		return "", nil, errors.New("no package document in META-INF/container.xml")
+	}
+
+	var pkg struct {
+		Titles   []string `xml:"metadata>title"`
+		Creators []string `xml:"metadata>creator"`
+		Items    []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if err := readXML(zr, opfPath, &pkg); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", opfPath, err)
+	}
+	hrefs := map[string]string{}
+	for _, it := range pkg.Items {
+		if it.MediaType == "application/xhtml+xml" || it.MediaType == "text/html" {
+			hrefs[it.ID] = it.Href
+		}
+	}
+
+	var chapters []string
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue // not a content document, or missing from the manifest
+		}
+		name, err := epubPath(opfPath, href)
+		if err != nil {
+			return "", nil, err
+		}
+		b, err := readEntry(zr, name)
+		if err != nil {
+			return "", nil, err
+		}
+		text, _, err := extractHTML(b)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if text != "" {
+			chapters = append(chapters, strings.TrimRight(text, "\n"))
+		}
+	}
+	text := strings.Join(chapters, "\n\n")
+	if text != "" {
+		text += "\n"
+	}
+
+	title := ""
+	if len(pkg.Titles) > 0 {
+		title = pkg.Titles[0]
+	}
+	return text, docMeta(title, strings.Join(pkg.Creators, ", ")), nil
+}
+
+// epubPath resolves a manifest href, which is a URL relative to the package
+// document, to a name in the archive.
+func epubPath(opfPath, href string) (string, error) {
+	href, _, _ = strings.Cut(href, "#")
+	p, err := url.PathUnescape(href)
+	if err != nil {
+		return "", fmt.Errorf("manifest href %q: %w", href, err)
+	}
+	return path.Join(path.Dir(opfPath), p), nil
+}
@@ -0,0 +1,510 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// extractHTML turns an HTML document into readable text: headings become
+// Markdown headings, list items "- " or "1. " lines and table rows cells
+// joined by " | ". Scripts, styles, form controls and navigation
+// boilerplate (nav, header, footer, aside) are dropped. The title and meta
+// description are returned as metadata.
+func extractHTML(b []byte) (text string, meta map[string]string, err error) {
+	doc, err := decodeHTML(b)
+	if err != nil {
+		return "", nil, err
+	}
+	e := &htmlExtractor{meta: map[string]string{}}
+	e.parse(doc)
+	if e.meta["title"] == "" && e.ogTitle != "" {
+		e.meta["title"] = e.ogTitle
+	}
+	if e.meta["description"] == "" && e.ogDescription != "" {
+		e.meta["description"] = e.ogDescription
+	}
+	for k, v := range e.meta {
+		if v = strings.Join(strings.Fields(v), " "); v == "" {
+			delete(e.meta, k)
+		} else {
+			e.meta[k] = v
+		}
+	}
+	if len(e.meta) == 0 {
+		e.meta = nil
+	}
+	text = strings.TrimSpace(e.out.String())
+	if text != "" {
+		text += "\n"
+	}
+	return text, e.meta, nil
+}
+
+var (
+	// skippedElements hold no readable content or only site boilerplate.
+	// Forms are kept, since some sites wrap the whole page in one, but
+	// their controls are not.
+	skippedElements = setOf("script", "style", "noscript", "template", "svg", "math", "iframe", "object",
+		"canvas", "nav", "aside", "button", "select", "dialog")
+	// pageElements are boilerplate outside an article, but hold its byline
+	// or notes inside one.
+	pageElements = setOf("header", "footer")
+	// skippedRoles mark boilerplate on generic elements.
+	skippedRoles = setOf("navigation", "banner", "contentinfo", "complementary", "search", "menu", "menubar")
+	// rawTextElements contain text up to their end tag, not markup.
+	rawTextElements = setOf("script", "style", "textarea", "title", "xmp", "noscript", "template")
+	voidElements    = setOf("area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta",
+		"param", "source", "track", "wbr")
+	blockElements = setOf("p", "div", "section", "article", "main", "blockquote", "figure", "figcaption",
+		"address", "details", "summary", "dl", "fieldset", "center", "ul", "ol", "table", "caption", "body")
+)
+
+func setOf(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+type htmlList struct {
+	ordered bool
+	n       int
+}
+
+// htmlExtractor walks the tags of a document and writes its text.
+type htmlExtractor struct {
+	out           bytes.Buffer
+	newlines      int  // newlines at the end of out
+	space         bool // whitespace is pending before the next word
+	pre           int  // depth of <pre> elements
+	skip          []string
+	lists         []htmlList
+	cells         []int // cells written in the current row, per open table
+	articles      int   // depth of <article> and <main> elements
+	meta          map[string]string
+	ogTitle       string
+	ogDescription string
+}
+
+func (e *htmlExtractor) parse(s string) {
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			j := strings.IndexByte(s[i:], '<')
+			if j < 0 {
+				j = len(s) - i
+			}
+			e.text(html.UnescapeString(s[i : i+j]))
+			i += j
+			continue
+		}
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				return
+			}
+			i += 4 + end + 3
+		case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return
+			}
+			i += end + 1
+		case strings.HasPrefix(rest, "</"):
+			name, n := tagName(rest[2:])
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return
+			}
+			if name != "" {
+				e.endTag(name)
+			}
+			i += max(end+1, 2+n)
+		case len(rest) > 1 && isASCIILetter(rest[1]):
+			name, attrs, n := parseStartTag(rest)
+			i += n
+			if strings.HasSuffix(rest[:n], "/>") && !voidElements[name] {
+				// an empty XHTML element such as <script src="x"/>, as
+				// found in EPUB chapters
+				e.startTag(name, attrs)
+				e.endTag(name)
+				continue
+			}
+			if rawTextElements[name] {
+				content, m := rawText(s[i:], name)
+				i += m
+				e.rawElement(name, content)
+				continue
+			}
+			e.startTag(name, attrs)
+		default:
+			e.text("<")
+			i++
+		}
+	}
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// tagName reads a lowercase tag name at the start of s.
+func tagName(s string) (string, int) {
+	n := 0
+	for n < len(s) && !strings.ContainsRune(" \t\n\r\f/>", rune(s[n])) {
+		n++
+	}
+	return strings.ToLower(s[:n]), n
+}
+
+// parseStartTag parses the tag at the start of s, which begins with "<" and
+// a letter, and returns its name, attributes and length.
+func parseStartTag(s string) (name string, attrs map[string]string, n int) {
+	name, n = tagName(s[1:])
+	n++
+	attrs = map[string]string{}
+	for n < len(s) {
+		c := s[n]
+		switch {
+		case c == '>':
+			return name, attrs, n + 1
+		case c == '/' || unicode.IsSpace(rune(c)):
+			n++
+			continue
+		}
+		start := n
+		for n < len(s) && !strings.ContainsRune(" \t\n\r\f/>=", rune(s[n])) {
+			n++
+		}
+		key := strings.ToLower(s[start:n])
+		for n < len(s) && unicode.IsSpace(rune(s[n])) {
+			n++
+		}
+		val := ""
+		if n < len(s) && s[n] == '=' {
+			n++
+			for n < len(s) && unicode.IsSpace(rune(s[n])) {
+				n++
+			}
+			if n < len(s) && (s[n] == '"' || s[n] == '\'') {
+				q := s[n]
+				end := strings.IndexByte(s[n+1:], q)
+				if end < 0 {
+					end = len(s) - n - 1
+				}
+				val = s[n+1 : n+1+end]
+				n += end + 2
+			} else {
+				start := n
+				for n < len(s) && !strings.ContainsRune(" \t\n\r\f>", rune(s[n])) {
+					n++
+				}
+				val = s[start:n]
+			}
+		}
+		if key != "" {
+			attrs[key] = html.UnescapeString(val)
+		}
+		if n == start {
+			n++ // not an attribute; skip the byte
+		}
+	}
+	return name, attrs, min(n, len(s))
+}
+
+// rawText returns the content of a raw text element up to its end tag and
+// the length consumed, including the end tag.
+func rawText(s, name string) (string, int) {
+	lower := strings.ToLower(s)
+	end := strings.Index(lower, "</"+name)
+	if end < 0 {
+		return s, len(s)
+	}
+	gt := strings.IndexByte(s[end:], '>')
+	if gt < 0 {
+		return s[:end], len(s)
+	}
+	return s[:end], end + gt + 1
+}
+
+func (e *htmlExtractor) skipping() bool {
+	return len(e.skip) > 0
+}
+
+func (e *htmlExtractor) rawElement(name, content string) {
+	switch name {
+	case "title":
+		if e.meta["title"] == "" {
+			e.meta["title"] = html.UnescapeString(content)
+		}
+	case "textarea", "xmp":
+		if !e.skipping() {
+			e.text(html.UnescapeString(content))
+		}
+	}
+}
+
+func (e *htmlExtractor) startTag(name string, attrs map[string]string) {
+	if name == "meta" {
+		e.metaTag(attrs)
+	}
+	if e.skipping() || e.boilerplate(name, attrs) {
+		if !voidElements[name] {
+			e.skip = append(e.skip, name)
+		}
+		return
+	}
+	switch name {
+	case "article", "main":
+		e.articles++
+		e.breakLines(2)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		e.breakLines(2)
+		e.write(strings.Repeat("#", int(name[1]-'0')) + " ")
+	case "br":
+		e.breakLines(1)
+	case "hr":
+		e.breakLines(2)
+	case "pre":
+		e.breakLines(2)
+		e.pre++
+	case "ul", "ol":
+		e.breakLines(1)
+		e.lists = append(e.lists, htmlList{ordered: name == "ol"})
+	case "li":
+		e.breakLines(1)
+		depth := max(len(e.lists), 1)
+		e.write(strings.Repeat("  ", depth-1))
+		if len(e.lists) > 0 && e.lists[len(e.lists)-1].ordered {
+			l := &e.lists[len(e.lists)-1]
+			l.n++
+			e.write(fmt.Sprintf("%d. ", l.n))
+		} else {
+			e.write("- ")
+		}
+	case "table":
+		e.breakLines(2)
+		e.cells = append(e.cells, 0)
+	case "tr":
+		e.breakLines(1)
+		if len(e.cells) > 0 {
+			e.cells[len(e.cells)-1] = 0
+		}
+	case "td", "th":
+		if len(e.cells) > 0 {
+			if e.cells[len(e.cells)-1] > 0 {
+				e.write(" | ")
+			}
+			e.cells[len(e.cells)-1]++
+		}
+	case "dt", "dd", "tbody", "thead", "tfoot":
+		e.breakLines(1)
+	case "img":
+		if alt := strings.TrimSpace(attrs["alt"]); alt != "" {
+			e.text(" " + alt + " ")
+		}
+	default:
+		if blockElements[name] {
+			e.breakLines(2)
+		}
+	}
+}
+
+// boilerplate reports whether an element holds site navigation or other
+// content that is not part of the document.
+func (e *htmlExtractor) boilerplate(name string, attrs map[string]string) bool {
+	if skippedElements[name] || pageElements[name] && e.articles == 0 {
+		return true
+	}
+	_, hidden := attrs["hidden"]
+	return hidden || attrs["aria-hidden"] == "true" || skippedRoles[strings.ToLower(attrs["role"])]
+}
+
+func (e *htmlExtractor) endTag(name string) {
+	if e.skipping() {
+		// close the innermost skipped element of this name and those inside it
+		for i := len(e.skip) - 1; i >= 0; i-- {
+			if e.skip[i] == name {
+				e.skip = e.skip[:i]
+				break
+			}
+		}
+		return
+	}
+	switch name {
+	case "article", "main":
+		e.articles = max(e.articles-1, 0)
+		e.breakLines(2)
+	case "h1", "h2", "h3", "h4", "h5", "h6", "hr":
+		e.breakLines(2)
+	case "pre":
+		e.pre = max(e.pre-1, 0)
+		e.breakLines(2)
+	case "ul", "ol":
+		if len(e.lists) > 0 {
+			e.lists = e.lists[:len(e.lists)-1]
+		}
+		e.breakLines(1)
+	case "table":
+		if len(e.cells) > 0 {
+			e.cells = e.cells[:len(e.cells)-1]
+		}
+		e.breakLines(2)
+	case "li", "tr", "dt", "dd":
+		e.breakLines(1)
+	default:
+		if blockElements[name] {
+			e.breakLines(2)
+		}
+	}
+}
+
+func (e *htmlExtractor) metaTag(attrs map[string]string) {
+	name := strings.ToLower(attrs["name"])
+	if name == "" {
+		name = strings.ToLower(attrs["property"])
+	}
+	switch name {
+	case "description":
+		e.meta["description"] = attrs["content"]
+	case "og:title":
+		e.ogTitle = attrs["content"]
+	case "og:description":
+		e.ogDescription = attrs["content"]
+	}
+}
+
+// text writes document text, collapsing whitespace outside <pre>.
+func (e *htmlExtractor) text(s string) {
+	if e.skipping() || s == "" {
+		return
+	}
+	if e.pre > 0 {
+		e.write(s)
+		return
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	last, _ := utf8.DecodeLastRuneInString(s)
+	if unicode.IsSpace(first) {
+		e.space = true
+	}
+	for _, word := range strings.Fields(s) {
+		if e.space && e.newlines == 0 && e.out.Len() > 0 && !bytes.HasSuffix(e.out.Bytes(), []byte(" ")) {
+			e.out.WriteByte(' ')
+		}
+		e.write(word)
+		e.space = true
+	}
+	e.space = unicode.IsSpace(last)
+}
+
+func (e *htmlExtractor) write(s string) {
+	if s == "" {
+		return
+	}
+	e.out.WriteString(s)
+	if trimmed := strings.TrimRight(s, "\n"); trimmed == "" {
+		e.newlines += len(s)
+	} else {
+		e.newlines = len(s) - len(trimmed)
+	}
+	e.space = false
+}
+
+// breakLines ends the current line and adds blank lines until there are n
+// newlines in a row, except at the start of the text.
+func (e *htmlExtractor) breakLines(n int) {
+	if e.out.Len() == 0 {
+		return
+	}
+	if e.newlines == 0 {
+		// drop a trailing space before the line break
+		b := e.out.Bytes()
+		e.out.Truncate(len(bytes.TrimRight(b, " ")))
+	}
+	for e.newlines < n {
+		e.out.WriteByte('\n')
+		e.newlines++
+	}
+	e.space = false
+}
+
+var charsetRe = regexp.MustCompile(`(?i)<(?:meta[^>]+charset|\?xml[^>]+encoding)\s*=\s*["']?\s*([a-z0-9_:.\-]+)`)
+
+// decodeHTML converts a document to UTF-8 using its byte order mark or the
+// charset declared in a <meta> tag or XML declaration within the first 1024
+// bytes. Documents that declare nothing and are not valid UTF-8 are read as
+// Windows-1252, as browsers do. Other charsets than UTF-8, UTF-16 and
+// Latin-1/Windows-1252 are not supported and return an error rather than
+// garbled text.
+func decodeHTML(b []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return strings.ToValidUTF8(string(b[3:]), "�"), nil
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return decodeUTF16(b[2:], false), nil
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return decodeUTF16(b[2:], true), nil
+	}
+	charset := ""
+	if m := charsetRe.FindSubmatch(b[:min(len(b), 1024)]); m != nil {
+		charset = strings.ToLower(string(m[1]))
+	}
+	switch charset {
+	case "iso-8859-1", "iso8859-1", "latin1", "l1", "windows-1252", "cp1252", "us-ascii", "ascii":
+		return decodeWindows1252(b), nil
+	case "":
+		if !utf8.Valid(b) {
+			return decodeWindows1252(b), nil
+		}
+	case "utf-8", "utf8", "unicode-1-1-utf-8", "utf-16", "utf-16le", "utf-16be":
+		// a UTF-16 document that can declare its charset in ASCII is not
+		// UTF-16; browsers read it as UTF-8
+	default:
+		return "", fmt.Errorf("html: unsupported charset %q", charset)
+	}
+	return strings.ToValidUTF8(string(b), "�"), nil
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		} else {
+			units[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// windows1252 maps bytes 0x80-0x9F, where Windows-1252 differs from
+// ISO-8859-1; unassigned bytes map to themselves.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(b []byte) string {
+	var sb strings.Builder
+	sb.Grow(len(b))
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			sb.WriteByte(c)
+		case c < 0xA0:
+			sb.WriteRune(windows1252[c-0x80])
+		default:
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String()
+}
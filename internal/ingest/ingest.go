@@ -9,7 +9,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/ledongthuc/pdf"
 )
@@ -17,12 +20,24 @@ import (
 var ErrUnsupported = errors.New("unsupported file type")
 
 type Source struct {
-	Path     string `json:"path"`
-	Kind     string `json:"kind"` // txt for now
-	SHA256   string `json:"sha256"`
-	TextPath string `json:"textPath"`
+	Path       string            `json:"path"`
+	Kind       string            `json:"kind"` // text, markdown, pdf, html, docx, odt, epub, code, csv, json or jsonl
+	SHA256     string            `json:"sha256"`
+	TextPath   string            `json:"textPath"`
+	IngestedAt time.Time         `json:"ingestedAt"`         // first time this path was ingested
+	UpdatedAt  time.Time         `json:"updatedAt,omitzero"` // last time its content changed
+	Pages      []int             `json:"pages,omitempty"`    // rune offsets at which pages start (see Extracted)
+	Meta       map[string]string `json:"meta,omitempty"`     // document metadata such as title (see Extracted)
+	// RecordsPath is the JSON file holding the rows of structured data
+	// (see Extracted), which are read into Records with the text.
+	RecordsPath string   `json:"recordsPath,omitempty"`
+	Records     []Record `json:"-"`
 }
 
+// skippedDirs are directories WalkPaths does not enter: version control
+// metadata and installed dependencies.
+var skippedDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, "node_modules": true}
+
 func WalkPaths(root string) ([]string, error) {
 	info, err := os.Stat(root)
 	if err != nil {
@@ -37,6 +52,9 @@ func WalkPaths(root string) ([]string, error) {
 			return err
 		}
 		if d.IsDir() {
+			if p != root && skippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		paths = append(paths, p)
@@ -53,56 +71,171 @@ func NormalizeText(s string) string {
 	return s
 }
 
-func ExtractText(path string) (kind string, text string, sum string, err error) {
+// Extracted is the text extracted from a file.
+type Extracted struct {
+	Kind   string // text, markdown, pdf, html, docx, odt, epub, code, csv, json or jsonl
+	Text   string // normalized text
+	SHA256 string // hash of Text
+	// Pages holds the rune offset in Text at which each page starts, for
+	// paginated formats; page n starts at Pages[n-1].
+	Pages []int
+	// Meta holds document metadata found by the extractor, such as the
+	// "title" and "description" of an HTML page or the "title" and "author"
+	// of a document or book, or the "language" of source code.
+	Meta map[string]string
+	// Records holds the rows of structured data files, in order. Each row
+	// is a document of its own, chunked separately.
+	Records []Record
+}
+
+// ExtractOptions enables extractors that are off by default.
+type ExtractOptions struct {
+	// Code accepts source files (see CodeLanguage) as kind "code".
+	Code bool
+	// Rows controls how rows of CSV, JSON and JSONL files become text.
+	Rows RowOptions
+}
+
+// Extract reads the file at path and extracts its text.
+func Extract(path string) (*Extracted, error) {
+	return ExtractWith(path, ExtractOptions{})
+}
+
+// ExtractWith is Extract with optional extractors enabled.
+func ExtractWith(path string, opt ExtractOptions) (*Extracted, error) {
+	if lang := CodeLanguage(path); opt.Code && lang != "" {
+		return extractCode(path, lang)
+	}
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
-	case ".txt", ".md":
-		kind = "text"
-		b, rerr := os.ReadFile(path)
-		if rerr != nil {
-			return "", "", "", rerr
+	case ".txt", ".md", ".markdown":
+		kind := "text"
+		if ext != ".txt" {
+			kind = "markdown"
 		}
-		norm := NormalizeText(string(b))
-		h := sha256.Sum256([]byte(norm))
-		return kind, norm, hex.EncodeToString(h[:]), nil
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return extracted(kind, string(b), nil), nil
 	case ".pdf":
-		kind = "pdf"
-		content, rerr := extractPDFText(path)
-		if rerr != nil {
-			return "", "", "", rerr
+		content, pages, err := extractPDFText(path)
+		if err != nil {
+			return nil, err
+		}
+		return extracted("pdf", content, pages), nil
+	case ".html", ".htm", ".xhtml":
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
 		}
-		norm := NormalizeText(content)
-		h := sha256.Sum256([]byte(norm))
-		return kind, norm, hex.EncodeToString(h[:]), nil
+		text, meta, err := extractHTML(b)
+		if err != nil {
+			return nil, err
+		}
+		e := extracted("html", text, nil)
+		e.Meta = meta
+		return e, nil
+	case ".docx", ".odt", ".epub":
+		return extractZip(path, ext[1:])
+	case ".csv", ".tsv":
+		return extractRows(path, "csv", opt.Rows)
+	case ".json":
+		return extractRows(path, "json", opt.Rows)
+	case ".jsonl", ".ndjson":
+		return extractRows(path, "jsonl", opt.Rows)
 	default:
-		return "", "", "", ErrUnsupported
+		return nil, ErrUnsupported
+	}
+}
+
+// extracted normalizes raw text and converts the byte offsets at which its
+// pages start into rune offsets in the normalized text.
+func extracted(kind, raw string, pageStarts []int) *Extracted {
+	norm := NormalizeText(raw)
+	h := sha256.Sum256([]byte(norm))
+	e := &Extracted{Kind: kind, Text: norm, SHA256: hex.EncodeToString(h[:])}
+	if len(pageStarts) > 0 {
+		e.Pages = normalizedOffsets(raw, pageStarts)
+	}
+	return e
+}
+
+// normalizedOffsets maps ascending byte offsets in raw to rune offsets in
+// NormalizeText(raw), walking raw once.
+func normalizedOffsets(raw string, offsets []int) []int {
+	out := make([]int, 0, len(offsets))
+	n := 0 // runes of the normalized text so far
+	for i := 0; len(out) < len(offsets); {
+		for len(out) < len(offsets) && (offsets[len(out)] <= i || i >= len(raw)) {
+			out = append(out, n)
+		}
+		if i >= len(raw) {
+			break
+		}
+		r, size := utf8.DecodeRuneInString(raw[i:])
+		// NUL is dropped and "\r\n" becomes a single newline
+		if r != 0 && !(r == '\r' && strings.HasPrefix(raw[i+1:], "\n")) {
+			n++
+		}
+		i += size
+	}
+	return out
+}
+
+// ExtractText is Extract returning only the kind, text and hash.
+func ExtractText(path string) (kind string, text string, sum string, err error) {
+	e, err := Extract(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	return e.Kind, e.Text, e.SHA256, nil
+}
+
+// Page returns the page on which the rune offset in the source's text lies,
+// counting from 1, or 0 if the source has no pages.
+func (s Source) Page(offset int) int {
+	return sort.Search(len(s.Pages), func(i int) bool { return s.Pages[i] > offset })
+}
+
+// Record returns the record (row) holding the rune offset in the source's
+// text, or nil if the source has no records or the offset lies between them.
+func (s Source) Record(offset int) *Record {
+	i := sort.Search(len(s.Records), func(i int) bool { return s.Records[i].End > offset })
+	if i < len(s.Records) && s.Records[i].Start <= offset {
+		return &s.Records[i]
 	}
+	return nil
 }
 
-func extractPDFText(path string) (string, error) {
+// extractPDFText returns the text of every page and the byte offset at which
+// each page starts in it. Unreadable pages are empty.
+func extractPDFText(path string) (string, []int, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	defer f.Close()
 
 	info, err := f.Stat()
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	r, err := pdf.NewReader(f, info.Size())
 	if err != nil {
 		// Check if it's an encrypted PDF
 		if strings.Contains(err.Error(), "encrypted") || strings.Contains(err.Error(), "password") {
-			return "", errors.New("encrypted PDF not supported")
+			return "", nil, errors.New("encrypted PDF not supported")
 		}
-		return "", err
+		return "", nil, err
 	}
 
 	var sb strings.Builder
 	numPages := r.NumPage()
+	starts := make([]int, 0, numPages)
 	for i := 1; i <= numPages; i++ {
+		starts = append(starts, sb.Len())
 		p := r.Page(i)
 		if p.V.IsNull() {
 			continue
@@ -116,7 +249,7 @@ func extractPDFText(path string) (string, error) {
 		sb.WriteString("\n")
 	}
 
-	return sb.String(), nil
+	return sb.String(), starts, nil
 }
 
 func CopyTo(dest string, r io.Reader) error {
@@ -1,8 +1,10 @@
 package ingest
 
 import (
+	"archive/zip"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -74,3 +76,395 @@ func TestExtractTextPDFEncrypted(t *testing.T) {
 		t.Fatalf("expected error message to contain 'encrypted', got %q", err.Error())
 	}
 }
+
+func TestExtractPages(t *testing.T) {
+	e, err := Extract(filepath.Join("testdata", "sample.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Pages) == 0 || e.Pages[0] != 0 {
+		t.Fatalf("expected the first page to start at 0, got %v", e.Pages)
+	}
+
+	// page offsets count runes of the normalized text
+	e = extracted("pdf", "é\r\nfirst\n\nsecond\n", []int{0, 9, 10})
+	if e.Text != "é\nfirst\n\nsecond\n" {
+		t.Fatalf("unexpected text %q", e.Text)
+	}
+	if want := []int{0, 7, 8}; !reflect.DeepEqual(e.Pages, want) {
+		t.Fatalf("got pages %v, want %v", e.Pages, want)
+	}
+	e = extracted("pdf", "a\x00b\rc\r\nd", []int{0, 2, 3, 5, 8, 8})
+	if want := []int{0, 1, 2, 4, 6, 6}; !reflect.DeepEqual(e.Pages, want) {
+		t.Fatalf("got pages %v, want %v", e.Pages, want)
+	}
+}
+
+func TestSourcePage(t *testing.T) {
+	src := Source{Pages: []int{0, 100, 100, 250}} // page 3 is empty
+	for offset, want := range map[int]int{0: 1, 99: 1, 100: 3, 249: 3, 250: 4, 9999: 4} {
+		if got := src.Page(offset); got != want {
+			t.Errorf("Page(%d) = %d, want %d", offset, got, want)
+		}
+	}
+	if got := (Source{}).Page(10); got != 0 {
+		t.Errorf("Page without pages = %d, want 0", got)
+	}
+}
+
+func TestExtractHTML(t *testing.T) {
+	e, err := Extract(filepath.Join("testdata", "sample.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != "html" {
+		t.Fatalf("expected kind=html, got %q", e.Kind)
+	}
+	want := "# Operation\n\n" +
+		"The pump runs at 40 bar. Check it daily.\n\n" +
+		"## Steps\n\n" +
+		"1. Open the valve\n" +
+		"2. Start the pump\n" +
+		"  - slowly\n\n" +
+		"Part | Limit\n" +
+		"Pump | 40 bar\n\n" +
+		"line 1\n  line 2\n\n" +
+		"Café\ncrème\n"
+	if e.Text != want {
+		t.Fatalf("got text\n%q\nwant\n%q", e.Text, want)
+	}
+	wantMeta := map[string]string{"title": "Pump & Valve Manual", "description": "How to run the pump."}
+	if !reflect.DeepEqual(e.Meta, wantMeta) {
+		t.Fatalf("got meta %v, want %v", e.Meta, wantMeta)
+	}
+}
+
+func TestExtractHTMLEmptyCells(t *testing.T) {
+	// rows ending in an empty cell leave no trailing space, however many
+	row := "<tr><td>a</td><td></td></tr>"
+	text, _, err := extractHTML([]byte("<table>" + strings.Repeat(row, 20000) + "</table><ul><li></li><li>b</li></ul>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("a |\n", 20000) + "\n-\n- b\n"; text != want {
+		t.Fatalf("got %q...", text[max(0, len(text)-40):])
+	}
+}
+
+func TestDecodeHTML(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"utf-8", []byte("<p>caf\xc3\xa9</p>"), "<p>café</p>"},
+		{"declared latin1", []byte(`<meta charset="iso-8859-1"><p>caf` + "\xe9"), `<meta charset="iso-8859-1"><p>café`},
+		{"http-equiv", []byte(`<meta http-equiv="Content-Type" content="text/html; charset=windows-1252">` + "\x93q\x94"), `<meta http-equiv="Content-Type" content="text/html; charset=windows-1252">“q”`},
+		{"undeclared invalid utf-8", []byte("caf\xe9"), "café"},
+		{"utf-8 bom", []byte("\xef\xbb\xbfhi"), "hi"},
+		{"utf-16le bom", []byte{0xFF, 0xFE, 'h', 0, 'i', 0}, "hi"},
+		{"xml declaration", []byte(`<?xml version="1.0" encoding="ISO-8859-1"?><p>caf` + "\xe9"), `<?xml version="1.0" encoding="ISO-8859-1"?><p>café`},
+	} {
+		got, err := decodeHTML(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+	for _, cs := range []string{"iso-8859-2", "shift_jis", "koi8-r", "gbk"} {
+		if _, err := decodeHTML([]byte(`<meta charset="` + cs + `"><p>x`)); err == nil {
+			t.Errorf("%s: expected unsupported charset error", cs)
+		}
+	}
+}
+
+func TestExtractHTMLKeepsForms(t *testing.T) {
+	text, _, err := extractHTML([]byte(`<body><form action="/post"><h1>Notice</h1><p>Read this.</p>` +
+		`<label>Name <input name="n"></label><select><option>one</option></select><button>Send</button></form></body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Notice\n\nRead this.\n\nName\n"; text != want {
+		t.Fatalf("got %q, want %q", text, want)
+	}
+}
+
+// writeZip writes an archive holding files, given as name/content pairs.
+func writeZip(t *testing.T, p string, files ...string) {
+	t.Helper()
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractDOCX(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "spec.docx")
+	writeZip(t, p,
+		"word/styles.xml", `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:pPr><w:outlineLvl w:val="1"/></w:pPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Spec2"><w:name w:val="Spec Heading"/><w:basedOn w:val="Heading2"/></w:style>
+</w:styles>`,
+		"word/document.xml", `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+  <w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr><w:r><w:t>Pump Spec</w:t></w:r></w:p>
+  <w:p><w:r><w:t xml:space="preserve">The pump runs at </w:t></w:r><w:r><w:rPr><w:b/></w:rPr><w:t>40 bar</w:t></w:r><w:r><w:t>.</w:t></w:r></w:p>
+  <w:p><w:pPr><w:pStyle w:val="Spec2"/><w:tabs><w:tab w:val="left" w:pos="720"/></w:tabs></w:pPr><w:r><w:t>Steps</w:t></w:r></w:p>
+  <w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>Open the valve</w:t></w:r></w:p>
+  <w:p><w:pPr><w:numPr><w:ilvl w:val="1"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>slowly</w:t></w:r><w:del><w:r><w:delText>quickly</w:delText></w:r></w:del></w:p>
+  <w:tbl>
+    <w:tr><w:tc><w:p><w:r><w:t>Part</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Limit</w:t></w:r></w:p></w:tc></w:tr>
+    <w:tr><w:tc><w:p><w:r><w:t>Pump</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>40</w:t></w:r><w:r><w:tab/><w:t>bar</w:t></w:r></w:p></w:tc></w:tr>
+  </w:tbl>
+  <w:p><w:r><w:t>Done.</w:t></w:r></w:p>
+</w:body></w:document>`,
+		"docProps/core.xml", `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <dc:title>Pump Spec</dc:title><dc:creator>Ada Lovelace</dc:creator>
+</cp:coreProperties>`,
+	)
+	e, err := Extract(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Pump Spec\n\n" +
+		"The pump runs at 40 bar.\n\n" +
+		"## Steps\n\n" +
+		"- Open the valve\n" +
+		"  - slowly\n\n" +
+		"Part | Limit\n" +
+		"Pump | 40\tbar\n\n" +
+		"Done.\n"
+	if e.Kind != "docx" || e.Text != want {
+		t.Fatalf("got kind %q text\n%q\nwant\n%q", e.Kind, e.Text, want)
+	}
+	wantMeta := map[string]string{"title": "Pump Spec", "author": "Ada Lovelace"}
+	if !reflect.DeepEqual(e.Meta, wantMeta) {
+		t.Fatalf("got meta %v, want %v", e.Meta, wantMeta)
+	}
+}
+
+func TestExtractODT(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "spec.odt")
+	writeZip(t, p,
+		"mimetype", "application/vnd.oasis.opendocument.text",
+		"content.xml", `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0">
+<office:body><office:text>
+  <text:sequence-decls><text:sequence-decl text:name="Table"/></text:sequence-decls>
+  <text:h text:outline-level="1">Operation</text:h>
+  <text:p>The pump   runs at<text:s text:c="2"/><text:span>40 bar</text:span>.<office:annotation><text:p>check this</text:p></office:annotation></text:p>
+  <text:list>
+    <text:list-item><text:p>Open the valve</text:p>
+      <text:list><text:list-item><text:p>slowly</text:p></text:list-item></text:list>
+    </text:list-item>
+  </text:list>
+  <table:table>
+    <table:table-row><table:table-cell><text:p>Part</text:p></table:table-cell><table:table-cell><text:p>Limit</text:p></table:table-cell></table:table-row>
+    <table:table-row><table:table-cell table:number-columns-spanned="2"><text:p>Pump</text:p></table:table-cell><table:covered-table-cell/></table:table-row>
+  </table:table>
+  <text:p>Footnote here<text:note><text:note-body><text:p>hidden</text:p></text:note-body></text:note>.</text:p>
+</office:text></office:body></office:document-content>`,
+		"meta.xml", `<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0">
+<office:meta><dc:title>Pump Manual</dc:title><meta:initial-creator>Grace Hopper</meta:initial-creator><dc:creator>Someone Else</dc:creator></office:meta>
+</office:document-meta>`,
+	)
+	e, err := Extract(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Operation\n\n" +
+		"The pump runs at  40 bar.\n\n" +
+		"- Open the valve\n" +
+		"  - slowly\n\n" +
+		"Part | Limit\n" +
+		"Pump\n\n" +
+		"Footnote here.\n"
+	if e.Kind != "odt" || e.Text != want {
+		t.Fatalf("got kind %q text\n%q\nwant\n%q", e.Kind, e.Text, want)
+	}
+	wantMeta := map[string]string{"title": "Pump Manual", "author": "Grace Hopper"}
+	if !reflect.DeepEqual(e.Meta, wantMeta) {
+		t.Fatalf("got meta %v, want %v", e.Meta, wantMeta)
+	}
+}
+
+func TestExtractEPUB(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "book.epub")
+	chapter := func(title, body string) string {
+		return `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>` + title + `</title><link rel="stylesheet" href="s.css"/><script src="x.js"/></head>
+<body>` + body + `</body></html>`
+	}
+	writeZip(t, p,
+		"mimetype", "application/epub+zip",
+		"META-INF/container.xml", `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+		"OEBPS/content.opf", `<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Pumps in Practice</dc:title><dc:creator>A. Author</dc:creator><dc:creator>B. Author</dc:creator>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="c1" href="text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="cover" href="cover.jpg" media-type="image/jpeg"/>
+  </manifest>
+  <spine><itemref idref="cover"/><itemref idref="c2"/><itemref idref="c1"/></spine>
+</package>`,
+		"OEBPS/nav.xhtml", chapter("Contents", `<nav><ol><li>Chapter 1</li></ol></nav>`),
+		"OEBPS/text/chapter 1.xhtml", chapter("One", `<h1>Chapter One</h1><p>Pumps move fluid.</p>`),
+		"OEBPS/text/ch2.xhtml", chapter("Two", `<h1>Chapter Two</h1><p>Valves stop it.</p>`),
+	)
+	e, err := Extract(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Chapter Two\n\nValves stop it.\n\n# Chapter One\n\nPumps move fluid.\n"
+	if e.Kind != "epub" || e.Text != want {
+		t.Fatalf("got kind %q text\n%q\nwant\n%q", e.Kind, e.Text, want)
+	}
+	wantMeta := map[string]string{"title": "Pumps in Practice", "author": "A. Author, B. Author"}
+	if !reflect.DeepEqual(e.Meta, wantMeta) {
+		t.Fatalf("got meta %v, want %v", e.Meta, wantMeta)
+	}
+}
+
+func TestExtractZipRejectsOversizedEntries(t *testing.T) {
+	// members that claim to inflate to a terabyte are refused before any of
+	// them is read, whether decoded whole or streamed
+	for _, tc := range []struct{ kind, member string }{
+		{"docx", "word/document.xml"},
+		{"docx", "word/styles.xml"},
+		{"odt", "content.xml"},
+	} {
+		p := filepath.Join(t.TempDir(), "bomb."+tc.kind)
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: tc.member, Method: zip.Store, UncompressedSize64: 1 << 40})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("<x/>")); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if _, err := Extract(p); err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Errorf("%s %s: got %v, want size error", tc.kind, tc.member, err)
+		}
+	}
+}
+
+func TestExtractRows(t *testing.T) {
+	d := t.TempDir()
+	csvPath := filepath.Join(d, "faq.csv")
+	if err := os.WriteFile(csvPath, []byte("\ufeffquestion,answer,product\n"+
+		"How fast?,\"40 bar,\nat most\",pump\n"+
+		"\"\",\"\",\n"+
+		"Leaks?,Tighten it,valve\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := ExtractWith(csvPath, ExtractOptions{Rows: RowOptions{
+		Template: "Q: {{.question}}\nA: {{.answer}}{{.missing}}",
+		Fields:   []string{"product", "missing"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Q: How fast?\nA: 40 bar,\nat most\n\nQ: \nA:\n\nQ: Leaks?\nA: Tighten it"
+	if e.Kind != "csv" || e.Text != want {
+		t.Fatalf("got kind %q text %q, want %q", e.Kind, e.Text, want)
+	}
+	if len(e.Records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", e.Records)
+	}
+	runes := []rune(e.Text)
+	if got := string(runes[e.Records[2].Start:e.Records[2].End]); got != "Q: Leaks?\nA: Tighten it" {
+		t.Fatalf("record 3 spans %q", got)
+	}
+	if e.Records[2].Row != 3 || !reflect.DeepEqual(e.Records[2].Meta, map[string]string{"product": "valve"}) {
+		t.Fatalf("unexpected record %+v", e.Records[2])
+	}
+
+	jsonlPath := filepath.Join(d, "tickets.jsonl")
+	if err := os.WriteFile(jsonlPath, []byte(`{"id": 7, "title": "Pump noise", "user": {"name": "ana"}}`+"\n\n"+
+		`{"id": 8, "title": "", "tags": ["a", "b"]}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err = ExtractWith(jsonlPath, ExtractOptions{Rows: RowOptions{Fields: []string{"id", "user.name"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "id: 7\ntitle: Pump noise\nuser: {\"name\":\"ana\"}\n\nid: 8\ntags: [\"a\",\"b\"]"
+	if e.Kind != "jsonl" || e.Text != want {
+		t.Fatalf("got kind %q text %q, want %q", e.Kind, e.Text, want)
+	}
+	if e.Records[0].Row != 1 || e.Records[1].Row != 3 {
+		t.Fatalf("records should carry their line numbers: %+v", e.Records)
+	}
+	if want := map[string]string{"id": "7", "user.name": "ana"}; !reflect.DeepEqual(e.Records[0].Meta, want) {
+		t.Fatalf("got meta %v, want %v", e.Records[0].Meta, want)
+	}
+
+	// missing and null fields render empty, but text that reads "<no value>"
+	// is kept
+	notesPath := filepath.Join(d, "notes.jsonl")
+	if err := os.WriteFile(notesPath, []byte(`{"note": "<no value> literally", "user": {"name": null}}`+"\n"+
+		`{"note": null}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err = ExtractWith(notesPath, ExtractOptions{Rows: RowOptions{Template: "{{.note}}|{{.user.name}}|{{.user.id}}|{{.other.x}}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want = "<no value> literally|||\n\n|||"; e.Text != want {
+		t.Fatalf("got text %q, want %q", e.Text, want)
+	}
+
+	jsonPath := filepath.Join(d, "bad.jsonl")
+	if err := os.WriteFile(jsonPath, []byte("{}\n{oops\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Extract(jsonPath); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error naming line 2, got %v", err)
+	}
+	if err := (RowOptions{Template: "{{.q"}).Validate(); err == nil {
+		t.Fatal("expected a template error")
+	}
+	if err := (RowOptions{Fields: []string{"product", "source"}}).Validate(); err == nil {
+		t.Fatal("expected an error for a reserved field name")
+	}
+}
+
+func TestSourceRecord(t *testing.T) {
+	src := Source{Records: []Record{{Row: 1, Start: 0, End: 10}, {Row: 2, Start: 12, End: 20}}}
+	for offset, want := range map[int]int{0: 1, 9: 1, 10: 0, 11: 0, 12: 2, 19: 2, 20: 0} {
+		got := 0
+		if r := src.Record(offset); r != nil {
+			got = r.Row
+		}
+		if got != want {
+			t.Errorf("Record(%d) is row %d, want %d", offset, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// odtSkipped are content.xml elements whose text is not part of the body:
+// comments, footnotes, tracked deletions and generated tables of contents.
+var odtSkipped = setOf("annotation", "note", "tracked-changes", "table-of-content", "illustration-index",
+	"alphabetical-index", "sequence-decls", "forms")
+
+// extractODT reads the body of an OpenDocument text file (content.xml) and
+// its title and author from meta.xml.
+func extractODT(zr *zip.Reader) (string, map[string]string, error) {
+	f, err := openEntry(zr, "content.xml")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	var (
+		w      docWriter
+		tables docTables
+		paras  []*odtParagraph // nested when a frame sits in a paragraph
+		lists  int             // depth of text:list elements
+		item   bool            // the next paragraph starts a list item
+	)
+	d := xml.NewDecoder(f)
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var p *odtParagraph
+			if len(paras) > 0 {
+				p = paras[len(paras)-1]
+			}
+			switch name := t.Name.Local; {
+			case odtSkipped[name]:
+				if err := d.Skip(); err != nil {
+					return "", nil, err
+				}
+			case name == "p":
+				paras = append(paras, &odtParagraph{item: item, list: lists})
+				item = false
+			case name == "h":
+				paras = append(paras, &odtParagraph{heading: atoiOr(attr(t, "outline-level"), 1)})
+				item = false
+			case name == "list":
+				lists++
+			case name == "list-item" || name == "list-header":
+				item = name == "list-item"
+			case name == "s" && p != nil:
+				p.text.WriteString(strings.Repeat(" ", max(atoiOr(attr(t, "c"), 1), 1)))
+			case name == "tab" && p != nil:
+				p.text.WriteString("\t")
+			case name == "line-break" && p != nil:
+				p.text.WriteString("\n")
+			case name == "table":
+				tables.open()
+			case name == "covered-table-cell":
+				// the part of a merged cell that holds no content
+				if err := d.Skip(); err != nil {
+					return "", nil, err
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p", "h":
+				if len(paras) == 0 {
+					continue
+				}
+				p := paras[len(paras)-1]
+				paras = paras[:len(paras)-1]
+				text := strings.TrimSpace(p.text.String())
+				switch {
+				case tables.inside():
+					tables.text(text)
+				case p.heading > 0:
+					w.heading(p.heading, text)
+				case p.item:
+					w.listItem(p.list-1, text)
+				case p.list > 0:
+					// a further paragraph of a list item
+					w.block(strings.Repeat("  ", p.list)+text, "list")
+				default:
+					w.block(text, "")
+				}
+			case "list":
+				lists = max(lists-1, 0)
+			case "table-cell":
+				if tables.inside() {
+					tables.endCell()
+				}
+			case "table-row":
+				if tables.inside() {
+					tables.endRow(&w)
+				}
+			case "table":
+				tables.close()
+			}
+		case xml.CharData:
+			if len(paras) > 0 {
+				// as in HTML, runs of whitespace in the markup are one space
+				paras[len(paras)-1].text.WriteString(collapseSpace(string(t)))
+			}
+		}
+	}
+
+	var meta struct {
+		Meta struct {
+			Title          string `xml:"title"`
+			Creator        string `xml:"creator"`
+			InitialCreator string `xml:"initial-creator"`
+		} `xml:"meta"`
+	}
+	if err := readXML(zr, "meta.xml", &meta); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, err
+	}
+	author := meta.Meta.InitialCreator
+	if author == "" {
+		author = meta.Meta.Creator
+	}
+	return w.String(), docMeta(meta.Meta.Title, author), nil
+}
+
+// odtParagraph is a paragraph or heading being read.
+type odtParagraph struct {
+	text    strings.Builder
+	heading int  // outline level of a heading, 0 for paragraphs
+	item    bool // the paragraph starts a list item
+	list    int  // depth of the lists the paragraph is in
+}
+
+// collapseSpace replaces each run of whitespace in s with a single space.
+func collapseSpace(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if space {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
@@ -0,0 +1,389 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+)
+
+// RowOptions controls how structured data files (.csv, .tsv, .json, .jsonl
+// and .ndjson) are turned into documents, one per row or object.
+type RowOptions struct {
+	// Template renders the text of a row with text/template. The row is a
+	// map from field name to value, so {{.question}} is the question field;
+	// JSON objects keep their nesting ({{.user.name}}) and missing fields
+	// render empty. Empty means every field as a "name: value" line.
+	Template string `json:"template,omitempty"`
+	// Fields are kept as metadata of the row's chunks. Nested JSON fields
+	// are named by their path, like user.name.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// reservedFields are the metadata keys the index sets on every chunk, or
+// on some kinds of source. A field of the same name would be shadowed by
+// them, so it cannot be kept.
+var reservedFields = setOf("source", "sourceSha", "kind", "chunkIdx", "totalChunks", "start", "end",
+	"heading", "page", "symbol", "startLine", "endLine", "row", "language", "title", "description", "author")
+
+// Validate reports a template that does not parse, or a field named like a
+// reserved metadata key.
+func (o RowOptions) Validate() error {
+	for _, f := range o.Fields {
+		if reservedFields[f] {
+			return fmt.Errorf("row field %q is a reserved metadata key", f)
+		}
+	}
+	_, err := o.template()
+	return err
+}
+
+func (o RowOptions) template() (*template.Template, error) {
+	if o.Template == "" {
+		return nil, nil
+	}
+	t, err := template.New("row").Option("missingkey=zero").Parse(o.Template)
+	if err != nil {
+		return nil, fmt.Errorf("row template: %w", err)
+	}
+	return t, nil
+}
+
+// Record is a row of a structured data file: the span of its text in the
+// extracted text and the fields kept as metadata.
+type Record struct {
+	Row   int               `json:"row"` // row, array element or line number, from 1
+	Start int               `json:"start"`
+	End   int               `json:"end"`
+	Meta  map[string]string `json:"meta,omitempty"`
+}
+
+// row is a decoded row with its field names in order.
+type row struct {
+	n      int
+	fields []string
+	values map[string]any
+}
+
+// extractRows reads a structured data file of the given kind and renders
+// each row with opt, separating rows with a blank line.
+func extractRows(path, kind string, opt RowOptions) (*Extracted, error) {
+	tmpl, err := opt.template()
+	if err != nil {
+		return nil, err
+	}
+	fields := templateFields(tmpl)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []row
+	switch kind {
+	case "csv":
+		rows, err = csvRows(f, strings.EqualFold(filepath.Ext(path), ".tsv"))
+	case "json":
+		rows, err = jsonRows(f)
+	case "jsonl":
+		rows, err = jsonlRows(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+
+	var sb strings.Builder
+	var records []Record
+	offset := 0
+	for _, r := range rows {
+		text, err := r.render(tmpl, fields)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", r.n, err)
+		}
+		text = strings.TrimSpace(NormalizeText(text))
+		if text == "" {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+			offset += 2
+		}
+		n := utf8.RuneCountInString(text)
+		records = append(records, Record{Row: r.n, Start: offset, End: offset + n, Meta: r.meta(opt.Fields)})
+		sb.WriteString(text)
+		offset += n
+	}
+	e := extracted(kind, sb.String(), nil)
+	e.Records = records
+	return e, nil
+}
+
+// render writes the row with tmpl, or as "name: value" lines without one.
+// fields are the field paths tmpl reads (see templateFields).
+func (r row) render(tmpl *template.Template, fields [][]string) (string, error) {
+	if tmpl == nil {
+		var sb strings.Builder
+		for _, f := range r.fields {
+			if v := valueString(r.values[f]); v != "" {
+				fmt.Fprintf(&sb, "%s: %s\n", f, v)
+			}
+		}
+		return sb.String(), nil
+	}
+	// missing fields of a map[string]any print as "<no value>" even with
+	// missingkey=zero, so the fields the template reads are filled in
+	values := maps.Clone(r.values)
+	for _, path := range fields {
+		fillField(values, path)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// fillField sets the field at path to "" if it is missing or null, cloning
+// the nested objects on the way so the row's own values are not changed.
+func fillField(values map[string]any, path []string) {
+	k := path[0]
+	if len(path) == 1 {
+		if v, ok := values[k]; !ok || v == nil {
+			values[k] = ""
+		}
+		return
+	}
+	switch v := values[k].(type) {
+	case nil:
+		nested := map[string]any{}
+		values[k] = nested
+		fillField(nested, path[1:])
+	case map[string]any:
+		nested := maps.Clone(v)
+		values[k] = nested
+		fillField(nested, path[1:])
+	}
+}
+
+// templateFields returns the field paths ({{.user.name}} is user, name)
+// read anywhere in tmpl and the templates it defines.
+func templateFields(tmpl *template.Template) [][]string {
+	if tmpl == nil {
+		return nil
+	}
+	var fields [][]string
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for _, a := range n.Args {
+				walk(a)
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.FieldNode:
+			fields = append(fields, n.Ident)
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	return fields
+}
+
+// meta returns the named fields of the row that have a value.
+func (r row) meta(fields []string) map[string]string {
+	var meta map[string]string
+	for _, name := range fields {
+		v, ok := lookup(r.values, name)
+		if !ok {
+			continue
+		}
+		if s := valueString(v); s != "" {
+			if meta == nil {
+				meta = map[string]string{}
+			}
+			meta[name] = s
+		}
+	}
+	return meta
+}
+
+// lookup finds a field by name, or by its dotted path in nested objects.
+func lookup(values map[string]any, name string) (any, bool) {
+	if v, ok := values[name]; ok {
+		return v, true
+	}
+	head, rest, ok := strings.Cut(name, ".")
+	if !ok {
+		return nil, false
+	}
+	nested, ok := values[head].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return lookup(nested, rest)
+}
+
+// valueString renders a field value as text: strings as they are, numbers
+// as written in the file and objects and arrays as JSON.
+func valueString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
+
+// csvRows reads a CSV (or, with tabs, TSV) file whose first line names the
+// fields.
+func csvRows(r io.Reader, tabs bool) ([]row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	if tabs {
+		cr.Comma = '\t'
+	}
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	for i := range header {
+		if header[i] = strings.TrimSpace(header[i]); header[i] == "" {
+			header[i] = fmt.Sprintf("column%d", i+1)
+		}
+	}
+
+	var rows []row
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := row{n: n, fields: header, values: map[string]any{}}
+		for i, name := range header {
+			if i < len(rec) {
+				r.values[name] = rec[i]
+			} else {
+				r.values[name] = ""
+			}
+		}
+		rows = append(rows, r)
+	}
+}
+
+// jsonRows reads a JSON array of objects, or a single object.
+func jsonRows(r io.Reader) ([]row, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	items, ok := v.([]any)
+	if !ok {
+		items = []any{v}
+	}
+	rows := make([]row, 0, len(items))
+	for i, item := range items {
+		rows = append(rows, objectRow(i+1, item))
+	}
+	return rows, nil
+}
+
+// jsonlRows reads one JSON value per line, skipping blank lines.
+func jsonlRows(r io.Reader) ([]row, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var rows []row
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.UseNumber()
+		var v any
+		if err := d.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rows = append(rows, objectRow(n, v))
+	}
+	return rows, sc.Err()
+}
+
+// objectRow makes a row of a JSON object, with its keys sorted. Other values
+// become a row with a single field, "value".
+func objectRow(n int, v any) row {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		obj = map[string]any{"value": v}
+	}
+	fields := make([]string, 0, len(obj))
+	for k := range obj {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	return row{n: n, fields: fields, values: obj}
+}
@@ -0,0 +1,205 @@
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// extractZip extracts a zipped XML document: a Word (.docx), OpenDocument
+// text (.odt) or EPUB file. kind is the file extension without the dot.
+func extractZip(path, kind string) (*Extracted, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	defer zr.Close()
+
+	var text string
+	var meta map[string]string
+	switch kind {
+	case "docx":
+		text, meta, err = extractDOCX(&zr.Reader)
+	case "odt":
+		text, meta, err = extractODT(&zr.Reader)
+	case "epub":
+		text, meta, err = extractEPUB(&zr.Reader)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	e := extracted(kind, text, nil)
+	e.Meta = meta
+	return e, nil
+}
+
+// maxEntrySize caps the uncompressed size of one archive member, so that a
+// small zip bomb cannot exhaust memory.
+const maxEntrySize = 64 << 20
+
+// openEntry opens the file name in the archive, refusing members whose
+// uncompressed size exceeds maxEntrySize. The zip reader fails reads past
+// the declared size, so the check bounds whatever is read from the file.
+func openEntry(zr *zip.Reader, name string) (fs.File, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if size := fi.Size(); size < 0 || size > maxEntrySize {
+		f.Close()
+		return nil, fmt.Errorf("%s: uncompressed size exceeds %d MiB", name, maxEntrySize>>20)
+	}
+	return f, nil
+}
+
+// readEntry reads the file name in the archive, at most maxEntrySize bytes.
+func readEntry(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := openEntry(zr, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxEntrySize {
+		return nil, fmt.Errorf("%s: uncompressed size exceeds %d MiB", name, maxEntrySize>>20)
+	}
+	return b, nil
+}
+
+// readXML decodes the XML file name in the archive into v.
+func readXML(zr *zip.Reader, name string, v any) error {
+	b, err := readEntry(zr, name)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// attr returns the value of the attribute with the given local name.
+func attr(se xml.StartElement, local string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// docMeta returns title and author as source metadata, or nil if both are
+// empty.
+func docMeta(title, author string) map[string]string {
+	meta := map[string]string{}
+	if title = strings.Join(strings.Fields(title), " "); title != "" {
+		meta["title"] = title
+	}
+	if author = strings.Join(strings.Fields(author), " "); author != "" {
+		meta["author"] = author
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// docWriter lays out the blocks of a word-processor document the way
+// extractHTML does: headings as Markdown headings, list items as "- " lines
+// and table rows with their cells joined by " | ".
+type docWriter struct {
+	sb   strings.Builder
+	last string // group of the last block
+}
+
+// block writes a paragraph, separated from the previous one by a blank line
+// unless both belong to the same group of lines, "list" or "table". Other
+// blocks have no group.
+func (w *docWriter) block(text, group string) {
+	text = strings.TrimRight(text, " \t\n")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	if w.sb.Len() > 0 {
+		if group != "" && group == w.last {
+			w.sb.WriteString("\n")
+		} else {
+			w.sb.WriteString("\n\n")
+		}
+	}
+	w.sb.WriteString(text)
+	w.last = group
+}
+
+func (w *docWriter) heading(level int, text string) {
+	w.block(strings.Repeat("#", min(max(level, 1), 6))+" "+strings.TrimSpace(text), "")
+}
+
+func (w *docWriter) listItem(depth int, text string) {
+	w.block(strings.Repeat("  ", depth)+"- "+strings.TrimSpace(text), "list")
+}
+
+func (w *docWriter) String() string {
+	if w.sb.Len() == 0 {
+		return ""
+	}
+	return w.sb.String() + "\n"
+}
+
+// docTable is a table being read, with the cells of its current row.
+type docTable struct {
+	cells []string
+	cell  strings.Builder
+}
+
+// docTables tracks the tables a paragraph is in. Paragraphs inside a cell
+// become part of the cell; finished rows are written to the document, or to
+// the enclosing cell for nested tables.
+type docTables []*docTable
+
+func (ts *docTables) open()       { *ts = append(*ts, &docTable{}) }
+func (ts docTables) inside() bool { return len(ts) > 0 }
+
+func (ts *docTables) close() {
+	if len(*ts) > 0 {
+		*ts = (*ts)[:len(*ts)-1]
+	}
+}
+
+func (ts docTables) text(s string) {
+	c := &ts[len(ts)-1].cell
+	if s = strings.TrimSpace(s); s == "" {
+		return
+	}
+	if c.Len() > 0 {
+		c.WriteString(" ")
+	}
+	c.WriteString(s)
+}
+
+func (ts docTables) endCell() {
+	t := ts[len(ts)-1]
+	t.cells = append(t.cells, t.cell.String())
+	t.cell.Reset()
+}
+
+func (ts docTables) endRow(w *docWriter) {
+	t := ts[len(ts)-1]
+	row := strings.Join(t.cells, " | ")
+	t.cells = nil
+	if len(ts) > 1 {
+		ts[:len(ts)-1].text(row)
+		return
+	}
+	w.block(row, "table")
+}
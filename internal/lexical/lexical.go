@@ -0,0 +1,380 @@
+// Package lexical implements a BM25 keyword index over chunk texts. It is
+// built next to the vector index and needs no embeddings, so it catches exact
+// identifiers and codes that semantic search tends to miss.
+package lexical
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Params are the BM25 ranking parameters.
+type Params struct {
+	K1 float64 // term frequency saturation
+	B  float64 // document length normalisation
+}
+
+// DefaultParams returns the usual BM25 settings.
+func DefaultParams() Params {
+	return Params{K1: 1.2, B: 0.75}
+}
+
+type posting struct {
+	doc       int32
+	positions []int32 // token positions; len(positions) is the term frequency
+}
+
+// Index is an inverted index from terms to the documents containing them.
+type Index struct {
+	Params Params
+
+	ids      []string
+	lens     []int32
+	totalLen int64
+	postings map[string][]posting
+}
+
+// New creates an empty index.
+func New() *Index {
+	return &Index{Params: DefaultParams(), postings: map[string][]posting{}}
+}
+
+// Count returns the number of documents in the index.
+func (x *Index) Count() int {
+	return len(x.ids)
+}
+
+// Terms returns the number of distinct terms in the index.
+func (x *Index) Terms() int {
+	return len(x.postings)
+}
+
+// Add indexes a document's text under id.
+func (x *Index) Add(id, text string) {
+	doc := int32(len(x.ids))
+	tokens := analyze(text)
+	positions := map[string][]int32{}
+	for _, t := range tokens {
+		positions[t.term] = append(positions[t.term], t.pos)
+	}
+	for t, pos := range positions {
+		x.postings[t] = append(x.postings[t], posting{doc: doc, positions: pos})
+	}
+	x.ids = append(x.ids, id)
+	x.lens = append(x.lens, int32(len(tokens)))
+	x.totalLen += int64(len(tokens))
+}
+
+// Hit is a search result.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Search returns up to topK documents ranked by BM25 score for the query
+// terms. Query and documents are analysed alike (see Analyze), so
+// "connections" finds "connected". Quoted phrases must occur in a document,
+// word for word up to stemming and stopwords, for it to match. Documents for
+// which accept returns false are skipped; a nil accept keeps every document.
+func (x *Index) Search(queryText string, topK int, accept func(id string) bool) []Hit {
+	if len(x.ids) == 0 || topK <= 0 {
+		return nil
+	}
+	q := parseQuery(queryText)
+	var required map[int32]bool // documents containing every phrase
+	for i, phrase := range q.phrases {
+		docs := x.phraseDocs(phrase)
+		if i > 0 {
+			for doc := range required {
+				if !docs[doc] {
+					delete(required, doc)
+				}
+			}
+		} else {
+			required = docs
+		}
+		for _, t := range phrase {
+			q.terms = append(q.terms, t.term)
+		}
+	}
+
+	n := float64(len(x.ids))
+	avg := float64(x.totalLen) / n
+	if avg == 0 {
+		avg = 1
+	}
+	k1, b := x.Params.K1, x.Params.B
+
+	scores := map[int32]float64{}
+	seen := map[string]bool{}
+	for _, t := range q.terms {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		list := x.postings[t]
+		if len(list) == 0 {
+			continue
+		}
+		df := float64(len(list))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range list {
+			if required != nil && !required[p.doc] {
+				continue
+			}
+			tf := float64(len(p.positions))
+			norm := k1 * (1 - b + b*float64(x.lens[p.doc])/avg)
+			scores[p.doc] += idf * tf * (k1 + 1) / (tf + norm)
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	order := make([]int32, 0, len(scores))
+	for doc := range scores {
+		order = append(order, doc)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		si, sj := scores[order[i]], scores[order[j]]
+		if si != sj {
+			return si > sj
+		}
+		return order[i] < order[j]
+	})
+	for _, doc := range order {
+		if len(hits) == topK {
+			break
+		}
+		if accept != nil && !accept(x.ids[doc]) {
+			continue
+		}
+		hits = append(hits, Hit{ID: x.ids[doc], Score: scores[doc]})
+	}
+	return hits
+}
+
+// phraseDocs returns the documents in which the phrase's terms occur at the
+// phrase's relative positions.
+func (x *Index) phraseDocs(phrase []token) map[int32]bool {
+	lists := make([][]posting, len(phrase))
+	for i, t := range phrase {
+		lists[i] = x.postings[t.term]
+		if len(lists[i]) == 0 {
+			return map[int32]bool{}
+		}
+	}
+	out := map[int32]bool{}
+	for _, first := range lists[0] {
+		rest := make([][]int32, len(phrase))
+		ok := true
+		for i := 1; i < len(phrase) && ok; i++ {
+			j := sort.Search(len(lists[i]), func(j int) bool { return lists[i][j].doc >= first.doc })
+			ok = j < len(lists[i]) && lists[i][j].doc == first.doc
+			if ok {
+				rest[i] = lists[i][j].positions
+			}
+		}
+		if !ok {
+			continue
+		}
+		for _, start := range first.positions {
+			match := true
+			for i := 1; i < len(phrase) && match; i++ {
+				want := start + phrase[i].pos
+				k := sort.Search(len(rest[i]), func(k int) bool { return rest[i][k] >= want })
+				match = k < len(rest[i]) && rest[i][k] == want
+			}
+			if match {
+				out[first.doc] = true
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Index file layout (little-endian): magic "OCNL", version uint32, document
+// count uint32, then per document the ID (uint32 length + bytes) and length
+// in terms (uint32), then the term count uint32 and per term the term
+// (uint32 length + bytes), posting count uint32 and per posting the document
+// uint32, the term frequency uint32 and that many uint32 positions.
+//
+// Version 2 added positions and the analyzer (stopwords and stemming);
+// version 1 files are rejected with ErrFormat so callers rebuild them.
+const (
+	magic   = "OCNL"
+	version = 2
+)
+
+// ErrFormat is returned when a file is not a readable lexical index.
+var ErrFormat = errors.New("unrecognised lexical index format")
+
+// Path returns the lexical index file that accompanies the vector index at
+// indexPath.
+func Path(indexPath string) string {
+	return strings.TrimSuffix(indexPath, filepath.Ext(indexPath)) + ".lex"
+}
+
+// Save writes the index to path, replacing it atomically.
+func (x *Index) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	if err := x.write(w); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write lexical index: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (x *Index) write(w io.Writer) error {
+	b := []byte(magic)
+	b = binary.LittleEndian.AppendUint32(b, version)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(x.ids)))
+	for i, id := range x.ids {
+		b = appendString(b, id)
+		b = binary.LittleEndian.AppendUint32(b, uint32(x.lens[i]))
+	}
+
+	terms := make([]string, 0, len(x.postings))
+	for t := range x.postings {
+		terms = append(terms, t)
+	}
+	sort.Strings(terms)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(terms)))
+	for _, t := range terms {
+		list := x.postings[t]
+		b = appendString(b, t)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(list)))
+		for _, p := range list {
+			b = binary.LittleEndian.AppendUint32(b, uint32(p.doc))
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(p.positions)))
+			for _, pos := range p.positions {
+				b = binary.LittleEndian.AppendUint32(b, uint32(pos))
+			}
+		}
+		if len(b) > 1<<16 {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			b = b[:0]
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// Load reads an index written by Save.
+func Load(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &reader{r: bufio.NewReader(f)}
+
+	var head [4]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil || string(head[:]) != magic {
+		return nil, ErrFormat
+	}
+	if v := r.u32(); r.err == nil && v != version {
+		return nil, fmt.Errorf("%w: version %d", ErrFormat, v)
+	}
+
+	x := New()
+	count := int(r.u32())
+	for range count {
+		if r.err != nil {
+			break
+		}
+		x.ids = append(x.ids, r.str())
+		n := int32(r.u32())
+		x.lens = append(x.lens, n)
+		x.totalLen += int64(n)
+	}
+	terms := int(r.u32())
+	for range terms {
+		if r.err != nil {
+			break
+		}
+		t := r.str()
+		n := int(r.u32())
+		if n > count {
+			return nil, fmt.Errorf("%w: posting list longer than the document count", ErrFormat)
+		}
+		list := make([]posting, n)
+		for i := range list {
+			doc, tf := int32(r.u32()), int(r.u32())
+			if r.err == nil && (doc < 0 || int(doc) >= count || tf > int(x.lens[doc])) {
+				return nil, fmt.Errorf("%w: posting out of range", ErrFormat)
+			}
+			list[i] = posting{doc: doc, positions: make([]int32, tf)}
+			for j := range list[i].positions {
+				list[i].positions[j] = int32(r.u32())
+			}
+		}
+		x.postings[t] = list
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, r.err)
+	}
+	return x, nil
+}
+
+// reader decodes the index file, remembering the first error.
+type reader struct {
+	r   *bufio.Reader
+	err error
+	buf [4]byte
+}
+
+func (r *reader) u32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		r.err = err
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.buf[:])
+}
+
+func (r *reader) str() string {
+	n := r.u32()
+	if r.err != nil {
+		return ""
+	}
+	if n > 1<<24 {
+		r.err = errors.New("string too long")
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,113 @@
+package lexical
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("Error E-1234 in ERR_CONN_42 (see v1.2.3). Done.")
+	want := []string{"error", "e-1234", "e", "1234", "in", "err_conn_42", "err", "conn", "42", "see", "v1.2.3", "v1", "2", "3", "done"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tokenize = %q\nwant %q", got, want)
+	}
+}
+
+func TestSearchRanksByBM25(t *testing.T) {
+	x := New()
+	x.Add("a", "the pump failed with error E-1234 after restart")
+	x.Add("b", "the pump was restarted and the pump ran fine")
+	x.Add("c", "unrelated text about gardening")
+
+	hits := x.Search("E-1234", 10, nil)
+	if len(hits) != 1 || hits[0].ID != "a" {
+		t.Fatalf("identifier search: %+v", hits)
+	}
+	hits = x.Search("pump", 10, nil)
+	if len(hits) != 2 || hits[0].ID != "b" {
+		t.Fatalf("term frequency should rank b first: %+v", hits)
+	}
+	hits = x.Search("pump", 10, func(id string) bool { return id != "b" })
+	if len(hits) != 1 || hits[0].ID != "a" {
+		t.Fatalf("accept not applied: %+v", hits)
+	}
+	if hits := x.Search("missing words", 10, nil); len(hits) != 0 {
+		t.Fatalf("expected no hits: %+v", hits)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	x := New()
+	x.Add("a", "alpha beta beta")
+	x.Add("b", "beta gamma")
+	path := filepath.Join(t.TempDir(), "index.lex")
+	if err := x.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	y, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y.Count() != 2 || y.Terms() != x.Terms() {
+		t.Fatalf("loaded %d docs and %d terms", y.Count(), y.Terms())
+	}
+	if a, b := x.Search("beta", 2, nil), y.Search("beta", 2, nil); !reflect.DeepEqual(a, b) {
+		t.Fatalf("results differ after reload: %+v vs %+v", a, b)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.lex")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestStem(t *testing.T) {
+	for word, want := range map[string]string{
+		"caresses":     "caress",
+		"ponies":       "poni",
+		"connected":    "connect",
+		"connecting":   "connect",
+		"connections":  "connect",
+		"hopping":      "hop",
+		"filing":       "file",
+		"happy":        "happi",
+		"relational":   "relat",
+		"generalizing": "gener",
+		"adjustment":   "adjust",
+		"controlling":  "control",
+		"e-1234":       "e-1234",
+		"über":         "über",
+	} {
+		if got := Stem(word); got != want {
+			t.Errorf("Stem(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	got := Analyze("The connections were failing")
+	want := []string{"connect", "fail"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Analyze = %q, want %q", got, want)
+	}
+}
+
+func TestSearchPhrases(t *testing.T) {
+	x := New()
+	x.Add("a", "the state of the art in pump design")
+	x.Add("b", "the art of pump design is in a sorry state")
+	x.Add("c", "pumps designed for state-of-the-art plants")
+
+	if hits := x.Search(`"state of the art"`, 10, nil); len(hits) != 2 || hits[0].ID == "b" || hits[1].ID == "b" {
+		t.Fatalf("phrase search: %+v", hits)
+	}
+	// "pumps designed" matches "pump design" after stemming
+	if hits := x.Search(`"pump design" sorry`, 10, nil); len(hits) != 3 || hits[0].ID != "b" {
+		t.Fatalf("phrase plus free term: %+v", hits)
+	}
+	if hits := x.Search(`"design pump"`, 10, nil); len(hits) != 0 {
+		t.Fatalf("word order ignored: %+v", hits)
+	}
+	if hits := x.Search("designing pumps", 10, nil); len(hits) != 3 {
+		t.Fatalf("stemmed search: %+v", hits)
+	}
+}
@@ -0,0 +1,112 @@
+package lexical
+
+import (
+	"strings"
+	"unicode"
+)
+
+// connectors join the parts of identifiers such as "ERR_CONN_42", "E-1234",
+// "v1.2.3" or "api/v2" when they sit between two letters or digits.
+const connectors = "_-.:/"
+
+// Tokenize splits text into lowercase terms. Runs of letters and digits are
+// terms; identifiers joined by connectors are kept whole and also split into
+// their parts, so "E-1234" yields "e-1234", "e" and "1234".
+func Tokenize(text string) []string {
+	var terms []string
+	scan(text, func(term string) { terms = append(terms, term) })
+	return terms
+}
+
+// Analyze turns text into index terms: Tokenize without stopwords and with
+// words reduced to their stems.
+func Analyze(text string) []string {
+	var terms []string
+	for _, t := range analyze(text) {
+		terms = append(terms, t.term)
+	}
+	return terms
+}
+
+// token is an analysed term and its position in the token stream. Stopwords
+// are dropped but still take a position, so phrases keep their spacing.
+type token struct {
+	term string
+	pos  int32
+}
+
+func analyze(text string) []token {
+	var tokens []token
+	pos := int32(0)
+	scan(text, func(term string) {
+		if !stopwords[term] {
+			tokens = append(tokens, token{term: Stem(term), pos: pos})
+		}
+		pos++
+	})
+	return tokens
+}
+
+func scan(text string, emit func(term string)) {
+	runes := []rune(strings.ToLower(text))
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+		start, compound := i, false
+		for i < len(runes) {
+			if isWordRune(runes[i]) {
+				i++
+				continue
+			}
+			if strings.ContainsRune(connectors, runes[i]) && i+1 < len(runes) && isWordRune(runes[i+1]) {
+				compound = true
+				i++
+				continue
+			}
+			break
+		}
+		word := string(runes[start:i])
+		emit(word)
+		if compound {
+			for _, part := range strings.FieldsFunc(word, func(r rune) bool {
+				return strings.ContainsRune(connectors, r)
+			}) {
+				emit(part)
+			}
+		}
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// query is a parsed search query: free terms ranked by BM25 and quoted
+// phrases that a document must contain.
+type query struct {
+	terms   []string
+	phrases [][]token // positions relative to the phrase start
+}
+
+// parseQuery splits q into phrases ("...") and free terms. An unterminated
+// quote runs to the end of the query.
+func parseQuery(q string) query {
+	var out query
+	for i, part := range strings.Split(q, `"`) {
+		tokens := analyze(part)
+		if i%2 == 0 || len(tokens) < 2 {
+			for _, t := range tokens {
+				out.terms = append(out.terms, t.term)
+			}
+			continue
+		}
+		base := tokens[0].pos
+		for j := range tokens {
+			tokens[j].pos -= base
+		}
+		out.phrases = append(out.phrases, tokens)
+	}
+	return out
+}
@@ -5,17 +5,28 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/ollama/ollama/api"
+
+	"github.com/winzerprince/oc-nlp/internal/retry"
 )
 
 type Config struct {
 	Host  string // http://localhost:11434
 	Model string // llama3.2:3b etc
+	// Retry controls retries and the per-call timeout; the zero value means
+	// the defaults from DefaultConfig.
+	Retry retry.Policy
 }
 
 func DefaultConfig() Config {
-	return Config{Host: "http://localhost:11434", Model: "llama3.2:3b"}
+	return Config{
+		Host:  "http://localhost:11434",
+		Model: "llama3.2:3b",
+		Retry: retry.Policy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 15 * time.Second, Timeout: 10 * time.Minute},
+	}
 }
 
 type Client struct {
@@ -44,13 +55,55 @@ func NewClient(cfg Config) (*Client, error) {
 func (c *Client) Generate(ctx context.Context, prompt string) (string, error) {
 	req := &api.GenerateRequest{Model: c.cfg.Model, Prompt: prompt, Stream: new(bool)}
 	*req.Stream = false
+	policy := c.cfg.Retry
+	if policy == (retry.Policy{}) {
+		policy = DefaultConfig().Retry
+	}
 	var out string
-	err := c.client.Generate(ctx, req, func(resp api.GenerateResponse) error {
-		out = resp.Response
-		return nil
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		return c.client.Generate(ctx, req, func(resp api.GenerateResponse) error {
+			out = resp.Response
+			return nil
+		})
 	})
 	if err != nil {
 		return "", fmt.Errorf("ollama generate: %w", err)
 	}
 	return out, nil
 }
+
+// GenerateStream is Generate with the answer streamed: fn is called with each
+// piece of the answer as the model produces it, and the whole answer is
+// returned at the end. Failures before the first piece are retried like
+// Generate; once pieces have been delivered the error is returned as is,
+// since a retry would repeat them. An error from fn stops the generation.
+func (c *Client) GenerateStream(ctx context.Context, prompt string, fn func(piece string) error) (string, error) {
+	req := &api.GenerateRequest{Model: c.cfg.Model, Prompt: prompt}
+	policy := c.cfg.Retry
+	if policy == (retry.Policy{}) {
+		policy = DefaultConfig().Retry
+	}
+	var out strings.Builder
+	var streamErr error
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		err := c.client.Generate(ctx, req, func(resp api.GenerateResponse) error {
+			if resp.Response == "" {
+				return nil
+			}
+			out.WriteString(resp.Response)
+			return fn(resp.Response)
+		})
+		if err != nil && out.Len() > 0 {
+			streamErr = err
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		err = streamErr
+	}
+	if err != nil {
+		return out.String(), fmt.Errorf("ollama generate: %w", err)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/winzerprince/oc-nlp/internal/retry"
+)
+
+func TestGenerateStream(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, piece := range []string{"The pump ", "runs at ", "40 bar."} {
+			_, _ = w.Write([]byte(`{"response":"` + piece + `","done":false}` + "\n"))
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte(`{"response":"","done":true}` + "\n"))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Host: srv.URL, Model: "fake", Retry: retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pieces []string
+	ans, err := c.GenerateStream(context.Background(), "prompt", func(p string) error {
+		pieces = append(pieces, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans != "The pump runs at 40 bar." || len(pieces) != 3 {
+		t.Fatalf("got %q in %d pieces", ans, len(pieces))
+	}
+
+	// an error from the callback stops the stream and is not retried
+	calls.Store(0)
+	stop := errors.New("client went away")
+	_, err = c.GenerateStream(context.Background(), "prompt", func(string) error { return stop })
+	if !errors.Is(err, stop) || calls.Load() != 1 {
+		t.Fatalf("err=%v after %d calls, want an error after 1", err, calls.Load())
+	}
+}
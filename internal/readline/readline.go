@@ -0,0 +1,320 @@
+// Package readline reads lines from a terminal with basic editing (cursor
+// movement, Emacs-style kill keys) and a history browsed with the arrow keys
+// and kept in a file between sessions. When input is not a terminal it reads
+// plain lines.
+package readline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ErrInterrupt is returned by ReadLine when the user presses Ctrl-C.
+var ErrInterrupt = errors.New("interrupted")
+
+// MaxHistory is the number of history entries kept in the history file.
+const MaxHistory = 1000
+
+// Reader reads edited lines from a terminal.
+type Reader struct {
+	in      *bufio.Reader
+	fd      int
+	out     io.Writer
+	history []string
+	path    string
+	histErr error // first failure to write the history file
+}
+
+// New creates a Reader on in, echoing to out. History is loaded from and
+// appended to historyPath; an empty path keeps it in memory only.
+func New(in *os.File, out io.Writer, historyPath string) *Reader {
+	r := &Reader{in: bufio.NewReader(in), fd: int(in.Fd()), out: out, path: historyPath}
+	if historyPath != "" {
+		if b, err := os.ReadFile(historyPath); err == nil {
+			for _, line := range strings.Split(string(b), "\n") {
+				if line != "" {
+					r.history = append(r.history, line)
+				}
+			}
+			r.history = r.history[max(len(r.history)-MaxHistory, 0):]
+		}
+	}
+	return r
+}
+
+// History returns the history entries, oldest first.
+func (r *Reader) History() []string {
+	return r.history
+}
+
+// AddHistory appends line to the history unless it is blank or repeats the
+// last entry.
+func (r *Reader) AddHistory(line string) error {
+	if strings.TrimSpace(line) == "" || (len(r.history) > 0 && r.history[len(r.history)-1] == line) {
+		return nil
+	}
+	r.history = append(r.history, line)
+	if r.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadLine shows prompt and reads a line, which it adds to the history. It
+// returns io.EOF on Ctrl-D at an empty line or at the end of input, and
+// ErrInterrupt on Ctrl-C. Failing to write the history file does not fail
+// ReadLine; see HistoryErr.
+func (r *Reader) ReadLine(prompt string) (string, error) {
+	restore, err := makeRaw(r.fd)
+	if err != nil {
+		return r.readPlain(prompt)
+	}
+	defer restore()
+
+	e := &editor{history: r.history, hpos: len(r.history)}
+	r.refresh(prompt, e)
+	for {
+		k, err := readKey(r.in)
+		if err != nil {
+			fmt.Fprint(r.out, "\r\n")
+			return "", err
+		}
+		switch e.handle(k) {
+		case done:
+			fmt.Fprint(r.out, "\r\n")
+			line := string(e.buf)
+			r.remember(line)
+			return line, nil
+		case eof:
+			fmt.Fprint(r.out, "\r\n")
+			return "", io.EOF
+		case interrupt:
+			fmt.Fprint(r.out, "^C\r\n")
+			return "", ErrInterrupt
+		}
+		r.refresh(prompt, e)
+	}
+}
+
+func (r *Reader) readPlain(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	line, err := r.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	r.remember(line)
+	return line, nil
+}
+
+// remember adds line to the history. After the history file fails to be
+// written once, the history is kept in memory only.
+func (r *Reader) remember(line string) {
+	if err := r.AddHistory(line); err != nil && r.histErr == nil {
+		r.histErr = err
+		r.path = ""
+	}
+}
+
+// HistoryErr returns the error that stopped ReadLine from writing the
+// history file, if any.
+func (r *Reader) HistoryErr() error {
+	return r.histErr
+}
+
+// refresh redraws the prompt and line and puts the cursor in place.
+func (r *Reader) refresh(prompt string, e *editor) {
+	s := "\r" + prompt + string(e.buf) + "\x1b[K"
+	if back := len(e.buf) - e.pos; back > 0 {
+		s += fmt.Sprintf("\x1b[%dD", back)
+	}
+	fmt.Fprint(r.out, s)
+}
+
+// Keys other than runes are negative.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+const (
+	ctrlA     = 1
+	ctrlB     = 2
+	ctrlC     = 3
+	ctrlD     = 4
+	ctrlE     = 5
+	ctrlF     = 6
+	ctrlH     = 8
+	ctrlK     = 11
+	ctrlN     = 14
+	ctrlP     = 16
+	ctrlU     = 21
+	ctrlW     = 23
+	esc       = 27
+	backspace = 127
+)
+
+// readKey reads one key press, decoding the escape sequences terminals send
+// for arrow, Home, End and Delete keys.
+func readKey(in *bufio.Reader) (rune, error) {
+	c, _, err := in.ReadRune()
+	if err != nil || c != esc {
+		return c, err
+	}
+	c, _, err = in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if c != '[' && c != 'O' {
+		return keyUnknown, nil
+	}
+	var param []rune
+	for {
+		c, _, err = in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if (c < '0' || c > '9') && c != ';' {
+			break
+		}
+		param = append(param, c)
+	}
+	switch c {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	case '~':
+		switch string(param) {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDelete, nil
+		}
+	}
+	return keyUnknown, nil
+}
+
+type action int
+
+const (
+	editing action = iota
+	done
+	eof
+	interrupt
+)
+
+// editor is the line being edited and the position in the history.
+type editor struct {
+	buf     []rune
+	pos     int // cursor position in buf
+	history []string
+	hpos    int    // history entry shown; len(history) is the new line
+	saved   []rune // the new line while browsing the history
+}
+
+func (e *editor) handle(k rune) action {
+	switch k {
+	case '\r', '\n':
+		return done
+	case ctrlC:
+		return interrupt
+	case ctrlD:
+		if len(e.buf) == 0 {
+			return eof
+		}
+		e.deleteAt(e.pos)
+	case backspace, ctrlH:
+		if e.pos > 0 {
+			e.pos--
+			e.deleteAt(e.pos)
+		}
+	case keyDelete:
+		e.deleteAt(e.pos)
+	case keyLeft, ctrlB:
+		e.pos = max(e.pos-1, 0)
+	case keyRight, ctrlF:
+		e.pos = min(e.pos+1, len(e.buf))
+	case keyHome, ctrlA:
+		e.pos = 0
+	case keyEnd, ctrlE:
+		e.pos = len(e.buf)
+	case ctrlK:
+		e.buf = e.buf[:e.pos]
+	case ctrlU:
+		e.buf = append([]rune(nil), e.buf[e.pos:]...)
+		e.pos = 0
+	case ctrlW:
+		start := e.pos
+		for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+			start--
+		}
+		e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+		e.pos = start
+	case keyUp, ctrlP:
+		e.browse(e.hpos - 1)
+	case keyDown, ctrlN:
+		e.browse(e.hpos + 1)
+	default:
+		if k >= ' ' && k != backspace {
+			e.buf = append(e.buf[:e.pos], append([]rune{k}, e.buf[e.pos:]...)...)
+			e.pos++
+		}
+	}
+	return editing
+}
+
+func (e *editor) deleteAt(i int) {
+	if i < len(e.buf) {
+		e.buf = append(e.buf[:i], e.buf[i+1:]...)
+	}
+}
+
+// browse shows history entry i, where len(history) is the new line.
+func (e *editor) browse(i int) {
+	if i < 0 || i > len(e.history) || i == e.hpos {
+		return
+	}
+	if e.hpos == len(e.history) {
+		e.saved = e.buf
+	}
+	e.hpos = i
+	if i == len(e.history) {
+		e.buf = e.saved
+	} else {
+		e.buf = []rune(e.history[i])
+	}
+	e.pos = len(e.buf)
+}
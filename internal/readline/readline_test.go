@@ -0,0 +1,149 @@
+package readline
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// edit feeds keys to a fresh editor and returns the line and last action.
+func edit(history []string, keys ...rune) (string, action) {
+	e := &editor{history: history, hpos: len(history)}
+	var act action
+	for _, k := range keys {
+		if act = e.handle(k); act != editing {
+			break
+		}
+	}
+	return string(e.buf), act
+}
+
+func keys(s string) []rune { return []rune(s) }
+
+func TestEditorEditing(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []rune
+		want string
+	}{
+		{"insert", keys("hello\r"), "hello"},
+		{"backspace", append(keys("helo"), backspace, backspace, 'l', 'l', 'o', '\r'), "hello"},
+		{"move and insert", append(keys("hllo"), ctrlA, keyRight, 'e', '\r'), "hello"},
+		{"delete at cursor", append(keys("hxello"), keyHome, keyRight, keyDelete, '\r'), "hello"},
+		{"kill to end", append(keys("hello world"), keyLeft, keyLeft, keyLeft, keyLeft, keyLeft, keyLeft, ctrlK, '\r'), "hello"},
+		{"kill to start", append(keys("well hello"), keyLeft, keyLeft, keyLeft, keyLeft, keyLeft, ctrlU, keyEnd, '\r'), "hello"},
+		{"delete word", append(keys("hello big  "), ctrlW, '\r'), "hello "},
+		{"unicode", append(keys("héllo"), keyLeft, ctrlH, '\r'), "hélo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, act := edit(nil, tt.keys...)
+			if act != done || got != tt.want {
+				t.Fatalf("got %q (action %d), want %q", got, act, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	history := []string{"first", "second"}
+	if got, _ := edit(history, keyUp, '\r'); got != "second" {
+		t.Fatalf("up: got %q", got)
+	}
+	if got, _ := edit(history, keyUp, keyUp, keyUp, '\r'); got != "first" {
+		t.Fatalf("up past the start: got %q", got)
+	}
+	// the line being typed comes back after browsing
+	if got, _ := edit(history, 'n', 'e', 'w', keyUp, keyUp, keyDown, keyDown, '\r'); got != "new" {
+		t.Fatalf("down: got %q", got)
+	}
+	// editing a recalled entry leaves the history alone
+	if got, _ := edit(history, keyUp, '!', '\r'); got != "second!" || history[1] != "second" {
+		t.Fatalf("edit recalled: got %q, history %q", got, history)
+	}
+}
+
+func TestEditorControlKeys(t *testing.T) {
+	if _, act := edit(nil, ctrlD); act != eof {
+		t.Fatalf("ctrl-d on empty line: action %d", act)
+	}
+	if got, act := edit(nil, 'a', 'b', keyLeft, ctrlD, '\r'); act != done || got != "a" {
+		t.Fatalf("ctrl-d deletes under the cursor: got %q", got)
+	}
+	if _, act := edit(nil, 'a', ctrlC); act != interrupt {
+		t.Fatalf("ctrl-c: action %d", act)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("\x1b[A\x1b[B\x1b[C\x1b[D\x1bOH\x1b[4~\x1b[3~\x1b[1;5Cé"))
+	want := []rune{keyUp, keyDown, keyRight, keyLeft, keyHome, keyEnd, keyDelete, keyRight, 'é'}
+	for i, w := range want {
+		k, err := readKey(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != w {
+			t.Fatalf("key %d: got %d, want %d", i, k, w)
+		}
+	}
+	if _, err := readKey(in); err != io.EOF {
+		t.Fatalf("want EOF, got %v", err)
+	}
+}
+
+func TestReadLinePlainAndHistoryFile(t *testing.T) {
+	dir := t.TempDir()
+	in, err := os.Create(filepath.Join(dir, "input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.WriteString("first question\r\n\nsecond question")
+	in.Seek(0, io.SeekStart)
+	defer in.Close()
+
+	hist := filepath.Join(dir, "history")
+	r := New(in, io.Discard, hist)
+	for _, want := range []string{"first question", "", "second question"} {
+		got, err := r.ReadLine("> ")
+		if err != nil || got != want {
+			t.Fatalf("got %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := r.ReadLine("> "); err != io.EOF {
+		t.Fatalf("want EOF, got %v", err)
+	}
+
+	r = New(in, io.Discard, hist)
+	if got := r.History(); len(got) != 2 || got[0] != "first question" || got[1] != "second question" {
+		t.Fatalf("history not persisted: %q", got)
+	}
+}
+
+func TestReadLineUnwritableHistory(t *testing.T) {
+	dir := t.TempDir()
+	in, err := os.Create(filepath.Join(dir, "input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.WriteString("first question\nsecond question\n")
+	in.Seek(0, io.SeekStart)
+	defer in.Close()
+
+	r := New(in, io.Discard, filepath.Join(dir, "missing", "history"))
+	for _, want := range []string{"first question", "second question"} {
+		got, err := r.ReadLine("> ")
+		if err != nil || got != want {
+			t.Fatalf("got %q, %v; want %q", got, err, want)
+		}
+	}
+	if r.HistoryErr() == nil {
+		t.Fatal("expected the history write error to be reported")
+	}
+	if got := r.History(); len(got) != 2 {
+		t.Fatalf("history should be kept in memory: %q", got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package readline
+
+import "errors"
+
+// makeRaw is not supported here, so lines are read without editing.
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
@@ -0,0 +1,173 @@
+// Package retry retries calls to Ollama with jittered exponential backoff and
+// classifies the errors those calls return.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Permanent failures. Errors returned by Policy.Do wrap one of these when the
+// failure cannot be fixed by trying again.
+var (
+	ErrModelNotFound = errors.New("model not found")
+	ErrContextLength = errors.New("input exceeds the model's context length")
+	ErrPermanent     = errors.New("permanent error")
+)
+
+// Policy configures how a call is retried.
+type Policy struct {
+	MaxAttempts int           // total attempts including the first; <= 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry, doubled for each further one
+	MaxDelay    time.Duration // upper bound for a single delay
+	Timeout     time.Duration // per-attempt timeout; 0 means none
+}
+
+// defaultBaseDelay is the first backoff of a policy without a BaseDelay.
+// Callers configure everything else; see the embeddings and llm defaults.
+const defaultBaseDelay = 500 * time.Millisecond
+
+// Do calls fn until it succeeds, fails permanently, the attempts are used up
+// or ctx is done. Each attempt gets its own context bounded by p.Timeout.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	attempts := max(p.MaxAttempts, 1)
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = p.attempt(ctx, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if perm := Permanent(err); perm != nil {
+			return fmt.Errorf("%w: %w", perm, err)
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		select {
+		case <-time.After(p.delay(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (p Policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// delay returns the full-jitter backoff before retry number attempt.
+func (p Policy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	if d <= 0 {
+		d = defaultBaseDelay
+	}
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Permanent returns the permanent error class of err (ErrModelNotFound,
+// ErrContextLength or ErrPermanent), or nil when err is worth retrying:
+// connection failures, timeouts, 429 and 5xx responses, and models that are
+// still loading.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range []error{ErrModelNotFound, ErrContextLength, ErrPermanent} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "not found") && strings.Contains(msg, "model"):
+		return ErrModelNotFound
+	case strings.Contains(msg, "context length"), strings.Contains(msg, "input length exceeds"),
+		strings.Contains(msg, "too many tokens"):
+		return ErrContextLength
+	}
+
+	var status api.StatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.StatusCode == http.StatusNotFound:
+			return ErrModelNotFound
+		case status.StatusCode == http.StatusTooManyRequests,
+			status.StatusCode == http.StatusRequestTimeout,
+			status.StatusCode >= 500:
+			return nil
+		default:
+			return ErrPermanent
+		}
+	}
+	var auth api.AuthorizationError
+	if errors.As(err, &auth) {
+		return ErrPermanent
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF),
+		errors.As(err, &netErr):
+		return nil
+	}
+	if strings.Contains(msg, "loading") || strings.Contains(msg, "connection refused") {
+		return nil
+	}
+	return ErrPermanent
+}
+
+// Unreachable reports whether err means the server could not be reached at
+// all (connection refused or reset, DNS failure, timeout) rather than that it
+// answered with an error.
+func Unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var status api.StatusError
+	if errors.As(err, &status) {
+		return false
+	}
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &dnsErr),
+		errors.As(err, &netErr):
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "no such host")
+}
@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func fastPolicy(attempts int) Policy {
+	return Policy{MaxAttempts: attempts, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	calls := 0
+	err := fastPolicy(4).Do(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return api.StatusError{StatusCode: http.StatusServiceUnavailable, ErrorMessage: "model is loading"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestDoGivesUp(t *testing.T) {
+	calls := 0
+	err := fastPolicy(3).Do(context.Background(), func(context.Context) error {
+		calls++
+		return fmt.Errorf("dial: %w", syscall.ECONNREFUSED)
+	})
+	if err == nil || calls != 3 {
+		t.Fatalf("expected failure after 3 calls, got %v after %d", err, calls)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("cause should be preserved: %v", err)
+	}
+}
+
+func TestDoStopsOnPermanentError(t *testing.T) {
+	calls := 0
+	err := fastPolicy(5).Do(context.Background(), func(context.Context) error {
+		calls++
+		return api.StatusError{StatusCode: http.StatusNotFound, ErrorMessage: `model "nope" not found, try pulling it first`}
+	})
+	if calls != 1 {
+		t.Fatalf("permanent errors must not be retried, got %d calls", calls)
+	}
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Fatalf("expected ErrModelNotFound, got %v", err)
+	}
+}
+
+func TestDoPerAttemptTimeout(t *testing.T) {
+	p := fastPolicy(2)
+	p.Timeout = 5 * time.Millisecond
+	calls := 0
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if calls != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected 2 timed-out attempts, got %d calls and %v", calls, err)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"server error", api.StatusError{StatusCode: 500}, nil},
+		{"rate limited", api.StatusError{StatusCode: 429}, nil},
+		{"refused", fmt.Errorf("post: %w", syscall.ECONNREFUSED), nil},
+		{"timeout", context.DeadlineExceeded, nil},
+		{"unknown model", api.StatusError{StatusCode: 404, ErrorMessage: "model not found"}, ErrModelNotFound},
+		{"context length", api.StatusError{StatusCode: 400, ErrorMessage: "the input length exceeds the context length"}, ErrContextLength},
+		{"bad request", api.StatusError{StatusCode: 400, ErrorMessage: "invalid input"}, ErrPermanent},
+		{"unauthorized", api.AuthorizationError{StatusCode: 401}, ErrPermanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Permanent(tt.err); got != tt.want {
+				t.Fatalf("Permanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("giving up after 4 attempts: %w", syscall.ECONNREFUSED), true},
+		{fmt.Errorf("post: %w", context.DeadlineExceeded), true},
+		{errors.New(`dial tcp: lookup ollama: no such host`), true},
+		{api.StatusError{StatusCode: 500}, false},
+		{fmt.Errorf("%w: %w", ErrModelNotFound, api.StatusError{StatusCode: 404}), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := Unreachable(tt.err); got != tt.want {
+			t.Errorf("Unreachable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -2,16 +2,22 @@ package server
 
 import (
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/winzerprince/oc-nlp/internal/chat"
 	"github.com/winzerprince/oc-nlp/internal/embeddings"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
 	"github.com/winzerprince/oc-nlp/internal/llm"
+	"github.com/winzerprince/oc-nlp/internal/vector"
 
 	"github.com/winzerprince/oc-nlp/internal/app"
 )
@@ -26,7 +32,7 @@ type App struct {
 }
 
 func Run(addr, dataDir string) error {
-	t, err := template.ParseFS(templatesFS, "templates/*.html")
+	t, err := template.New("").Funcs(funcs).ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
 		return err
 	}
@@ -37,6 +43,9 @@ func Run(addr, dataDir string) error {
 	mux.HandleFunc("/", app.handleHome)
 	mux.HandleFunc("/models/create", app.handleCreateModel)
 	mux.HandleFunc("/chat", app.handleChat)
+	mux.HandleFunc("/chat/stream", app.handleChatStream)
+	mux.HandleFunc("/conversations/delete", app.handleDeleteConversation)
+	mux.HandleFunc("GET /models/{name}/sources/{sha}", app.handleSource)
 	mux.HandleFunc("/ingest/path", app.handleIngestPath)
 	mux.HandleFunc("/ingest/upload", app.handleIngestUpload)
 
@@ -45,6 +54,39 @@ func Run(addr, dataDir string) error {
 	return srv.ListenAndServe()
 }
 
+var funcs = template.FuncMap{
+	"sourceURL": sourceURL,
+	"cite":      linkCitations,
+	"add":       func(a, b int) int { return a + b },
+}
+
+// sourceURL links to a source in the source viewer, scrolled to a chunk.
+func sourceURL(model, sha string, chunkIdx int) string {
+	return fmt.Sprintf("/models/%s/sources/%s?chunk=%d#chunk-%d", url.PathEscape(model), url.PathEscape(sha), chunkIdx, chunkIdx)
+}
+
+// linkCitations escapes an answer and links its [n] markers to the cited
+// passages' entries (#cite-n) in the page's source list.
+func linkCitations(answer string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, m := range chat.CitationPattern.FindAllStringIndex(answer, -1) {
+		b.WriteString(template.HTMLEscapeString(answer[last:m[0]]))
+		b.WriteString("[")
+		for i, n := range strings.Split(answer[m[0]+1:m[1]-1], ",") {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			n = strings.TrimSpace(n)
+			fmt.Fprintf(&b, `<a href="#cite-%s">%s</a>`, n, n)
+		}
+		b.WriteString("]")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(answer[last:]))
+	return template.HTML(b.String())
+}
+
 func (a *App) handleHome(w http.ResponseWriter, r *http.Request) {
 	models, _ := a.Store.ListModels()
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -68,40 +110,181 @@ func (a *App) handleCreateModel(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// chatForm is the chat page's form, read from a POST body or, for the
+// stream endpoint, from the query string.
+type chatForm struct {
+	Query, Filter string
+	MMR, Rerank   bool
+}
+
+func readChatForm(r *http.Request) chatForm {
+	return chatForm{
+		Query:  r.FormValue("q"),
+		Filter: r.FormValue("filter"),
+		MMR:    r.FormValue("mmr") != "",
+		Rerank: r.FormValue("rerank") != "",
+	}
+}
+
+// options returns the retrieval options the form asks for.
+func (f chatForm) options(llmCfg llm.Config) (chat.Options, error) {
+	opt := chat.Options{SearchOptions: app.SearchOptions{TopK: 4}}
+	if f.MMR {
+		lambda := 0.5
+		opt.MMRLambda = &lambda
+	}
+	if f.Rerank {
+		opt.Reranker = chat.NewLLMReranker(llmCfg)
+	}
+	filter, err := vector.ParseFilter(f.Filter)
+	opt.Filter = filter
+	return opt, err
+}
+
+// conversation loads the conversation with the given id, or starts a new one
+// when id is empty.
+func (a *App) conversation(model, id string) (*app.Conversation, error) {
+	if id == "" {
+		return a.Store.NewConversation(model)
+	}
+	return a.Store.GetConversation(model, id)
+}
+
 func (a *App) handleChat(w http.ResponseWriter, r *http.Request) {
 	model := r.URL.Query().Get("model")
 	if model == "" {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	query := ""
+	var form chatForm
 	if r.Method == http.MethodPost {
-		query = r.FormValue("q")
+		form = readChatForm(r)
 	}
-	var res any
+	var res *chat.Result
 	var errMsg string
-	if query != "" {
-		ctx := r.Context()
+	conv, err := a.conversation(model, r.FormValue("c"))
+	if err != nil {
+		errMsg = err.Error()
+	} else if form.Query != "" {
 		embCfg := embeddings.DefaultConfig()
 		llmCfg := llm.DefaultConfig()
-		r, err := chat.Ask(ctx, a.Store, model, query, 4, embCfg, llmCfg)
+		opt, err := form.options(llmCfg)
+		opt.History = conv.Turns
 		if err != nil {
 			errMsg = err.Error()
+		} else if res, err = chat.AskWith(r.Context(), a.Store, model, form.Query, opt, embCfg, llmCfg); err != nil {
+			errMsg = err.Error()
 		} else {
-			res = r
+			conv.AddTurn(form.Query, res.Query, res.Answer)
+			if err := a.Store.SaveConversation(conv); err != nil {
+				errMsg = err.Error()
+			}
 		}
 	}
+	// the latest turn is shown with its passages below the history
+	var history []app.Turn
+	if conv != nil {
+		history = conv.Turns
+		if res != nil && len(history) > 0 {
+			history = history[:len(history)-1]
+		}
+	}
+	conversations, _ := a.Store.ListConversations(model)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_ = a.T.ExecuteTemplate(w, "chat.html", map[string]any{
-		"Title":  "oc-nlp chat",
-		"Model":  model,
-		"Query":  query,
-		"Result": res,
-		"Error":  errMsg,
+		"Title":         "oc-nlp chat",
+		"Model":         model,
+		"Query":         form.Query,
+		"Filter":        form.Filter,
+		"MMR":           form.MMR,
+		"Rerank":        form.Rerank,
+		"Conversation":  conv,
+		"Conversations": conversations,
+		"History":       history,
+		"Result":        res,
+		"Error":         errMsg,
 	})
 }
 
+// handleChatStream answers a chat question as Server-Sent Events: a
+// "retrieved" event with the search query, passages and prompt, a "token"
+// event per piece of the answer, then "done" with the whole answer and the
+// conversation it was saved to, or "error" if anything fails. Event data is
+// JSON.
+func (a *App) handleChatStream(w http.ResponseWriter, r *http.Request) {
+	model := r.URL.Query().Get("model")
+	form := readChatForm(r)
+	if model == "" || form.Query == "" {
+		http.Error(w, "model and q are required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	send := func(event string, data any) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	fail := func(err error) {
+		_ = send("error", map[string]string{"error": err.Error()})
+	}
+
+	conv, err := a.conversation(model, r.FormValue("c"))
+	if err != nil {
+		fail(err)
+		return
+	}
+	embCfg := embeddings.DefaultConfig()
+	llmCfg := llm.DefaultConfig()
+	opt, err := form.options(llmCfg)
+	if err != nil {
+		fail(err)
+		return
+	}
+	opt.History = conv.Turns
+	res, err := chat.AskStream(r.Context(), a.Store, model, form.Query, opt, embCfg, llmCfg,
+		func(res *chat.Result) error {
+			return send("retrieved", map[string]any{"query": res.Query, "retrieved": res.Retrieved, "prompt": res.AssembledPrompt})
+		},
+		func(token string) error { return send("token", token) },
+	)
+	if err != nil {
+		fail(err)
+		return
+	}
+	conv.AddTurn(form.Query, res.Query, res.Answer)
+	if err := a.Store.SaveConversation(conv); err != nil {
+		fail(err)
+		return
+	}
+	_ = send("done", map[string]any{"answer": res.Answer, "citations": res.Citations, "conversation": conv.ID})
+}
+
+func (a *App) handleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	model := r.FormValue("model")
+	if err := a.Store.DeleteConversation(model, r.FormValue("c")); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.Redirect(w, r, "/chat?model="+url.QueryEscape(model), http.StatusSeeOther)
+}
+
 func (a *App) handleIngestPath(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -117,7 +300,16 @@ func (a *App) handleIngestPath(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unknown model: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := a.Store.IngestSources(model, path); err != nil {
+	opt := app.IngestOptions{Code: r.FormValue("code") != ""}
+	if tmpl, fields := r.FormValue("template"), r.FormValue("fields"); tmpl != "" || fields != "" {
+		opt.Rows = &ingest.RowOptions{Template: tmpl}
+		for _, f := range strings.Split(fields, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				opt.Rows.Fields = append(opt.Rows.Fields, f)
+			}
+		}
+	}
+	if _, err := a.Store.IngestSourcesWith(model, path, opt); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -164,7 +356,7 @@ func (a *App) handleIngestUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	_ = out.Close()
 
-	if err := a.Store.IngestSources(model, dest); err != nil {
+	if _, err := a.Store.IngestSources(model, dest); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/winzerprince/oc-nlp/internal/chunk"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
+)
+
+// chunkMark describes a chunk at one of its boundaries in the source viewer.
+type chunkMark struct {
+	Index, Start, End, Page int
+}
+
+// segment is a run of source text between two chunk boundaries.
+type segment struct {
+	Ends      []chunkMark // chunks ending before the text
+	Starts    []chunkMark // chunks starting with the text
+	Text      string
+	Covered   int  // number of chunks containing the text; 2 or more is overlap
+	Highlight bool // the text belongs to the selected chunk
+}
+
+// segments cuts text at every chunk start and end. selected is the index of
+// the chunk to highlight, or -1.
+func segments(src ingest.Source, text string, chunks []chunk.Chunk, selected int) []segment {
+	runes := []rune(text)
+	mark := func(c chunk.Chunk) chunkMark {
+		return chunkMark{Index: c.Index, Start: c.Start, End: c.End, Page: src.Page(c.Start)}
+	}
+	starts := map[int][]chunkMark{}
+	ends := map[int][]chunkMark{}
+	cuts := []int{0, len(runes)}
+	for _, c := range chunks {
+		start, end := min(max(c.Start, 0), len(runes)), min(max(c.End, 0), len(runes))
+		starts[start] = append(starts[start], mark(c))
+		ends[end] = append(ends[end], mark(c))
+		cuts = append(cuts, start, end)
+	}
+	sort.Ints(cuts)
+
+	var out []segment
+	for i, at := range cuts {
+		if i > 0 && at == cuts[i-1] {
+			continue
+		}
+		next := len(runes)
+		for _, c := range cuts[i+1:] {
+			if c > at {
+				next = c
+				break
+			}
+		}
+		seg := segment{Ends: ends[at], Starts: starts[at], Text: string(runes[at:next])}
+		for _, c := range chunks {
+			if c.Start <= at && at < c.End {
+				seg.Covered++
+				seg.Highlight = seg.Highlight || c.Index == selected
+			}
+		}
+		if seg.Text != "" || len(seg.Ends) > 0 || len(seg.Starts) > 0 {
+			out = append(out, seg)
+		}
+	}
+	return out
+}
+
+// handleSource shows the text extracted from a source with its chunk
+// boundaries marked, highlighting the chunk given by ?chunk=N.
+func (a *App) handleSource(w http.ResponseWriter, r *http.Request) {
+	model, sha := r.PathValue("name"), r.PathValue("sha")
+	st, err := a.Store.GetSourceText(model, sha)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	selected := -1
+	if v := r.URL.Query().Get("chunk"); v != "" {
+		if selected, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid chunk index", http.StatusBadRequest)
+			return
+		}
+	}
+	var sel *chunkMark
+	for _, c := range st.Chunks {
+		if c.Index == selected {
+			sel = &chunkMark{Index: c.Index, Start: c.Start, End: c.End, Page: st.Source.Page(c.Start)}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = a.T.ExecuteTemplate(w, "source.html", map[string]any{
+		"Title":    "oc-nlp source",
+		"Model":    model,
+		"Source":   st.Source,
+		"Chunks":   len(st.Chunks),
+		"Selected": sel,
+		"Segments": segments(st.Source, st.Text, st.Chunks, selected),
+	})
+}
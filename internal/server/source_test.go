@@ -0,0 +1,42 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/winzerprince/oc-nlp/internal/chunk"
+	"github.com/winzerprince/oc-nlp/internal/ingest"
+)
+
+func TestSegments(t *testing.T) {
+	text := "aaaabbbbccccdd"
+	chunks := []chunk.Chunk{
+		{Index: 0, Start: 0, End: 8},  // aaaabbbb
+		{Index: 1, Start: 4, End: 12}, // bbbbcccc, overlapping chunk 0
+	}
+	src := ingest.Source{Pages: []int{0, 6}}
+	segs := segments(src, text, chunks, 1)
+
+	var texts []string
+	for _, s := range segs {
+		texts = append(texts, s.Text)
+	}
+	if got := strings.Join(texts, "|"); got != "aaaa|bbbb|cccc|dd" {
+		t.Fatalf("got segments %q", got)
+	}
+	if len(segs[0].Starts) != 1 || segs[0].Starts[0].Index != 0 || segs[0].Starts[0].Page != 1 {
+		t.Fatalf("chunk 0 should start the first segment: %+v", segs[0])
+	}
+	if segs[1].Covered != 2 || !segs[1].Highlight || segs[1].Starts[0].Index != 1 {
+		t.Fatalf("overlap should be covered twice and highlighted: %+v", segs[1])
+	}
+	if segs[2].Ends[0].Index != 0 || segs[2].Covered != 1 || !segs[2].Highlight {
+		t.Fatalf("chunk 0 should end before the third segment: %+v", segs[2])
+	}
+	if segs[3].Ends[0].Index != 1 || segs[3].Covered != 0 || segs[3].Highlight {
+		t.Fatalf("the tail is in no chunk: %+v", segs[3])
+	}
+	if segs[0].Highlight {
+		t.Fatal("chunk 0 alone should not be highlighted")
+	}
+}
@@ -0,0 +1,315 @@
+package vector
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+// Binary index layout (all integers little-endian):
+//
+//	magic   [4]byte  "OCNV"
+//	version uint32   FormatVersion
+//	dim     uint32   embedding dimension
+//	count   uint64   number of documents
+//	mlen    uint32   length of the embedding model name
+//	model   [mlen]byte
+//	docsSum [32]byte SHA-256 of the documents file (from version 2)
+//	padding to a multiple of 16 bytes
+//	vectors [count*dim]float32, row-major in document order
+//
+// IDs, texts and metadata live in a separate JSON-lines file next to it (see
+// DocsPath), one document per line in the same order as the vectors. The two
+// files are renamed into place one after the other; docsSum lets Open tell
+// when a crash in between left them from different saves.
+const (
+	FormatVersion = 2
+	magic         = "OCNV"
+	headerAlign   = 16
+	// sidecarVersion is the version of the HNSW graph and IVF clusters files.
+	sidecarVersion = 1
+)
+
+// ErrFormat is returned when a file is not a readable vector index.
+var ErrFormat = errors.New("unrecognised vector index format")
+
+// docRecord is a line of the documents file.
+type docRecord struct {
+	ID       string   `json:"id"`
+	Text     string   `json:"text"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// DocsPath returns the documents file that accompanies the binary index at path.
+func DocsPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".docs.jsonl"
+}
+
+// Save persists the index to disk in the binary format: the vectors at path
+// and the documents at DocsPath(path). Each file is replaced atomically, the
+// documents first; the vectors file records their checksum, so Open rejects
+// a pair left behind by a crash between the two.
+func (idx *Index) Save(path string) error {
+	dim := 0
+	for i := range idx.Documents {
+		n := len(idx.embedding(i))
+		if i == 0 {
+			dim = n
+		}
+		if n == 0 || n != dim {
+			return fmt.Errorf("document %q: embedding dimension %d, want %d", idx.Documents[i].ID, n, dim)
+		}
+	}
+
+	sum := sha256.New()
+	if err := writeAtomic(DocsPath(path), func(w io.Writer) error {
+		enc := json.NewEncoder(io.MultiWriter(w, sum))
+		for _, doc := range idx.Documents {
+			if err := enc.Encode(docRecord{ID: doc.ID, Text: doc.Text, Metadata: doc.Metadata}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("write documents: %w", err)
+	}
+
+	if err := writeAtomic(path, func(w io.Writer) error {
+		if _, err := w.Write(encodeHeader(dim, len(idx.Documents), idx.EmbeddingModel, sum.Sum(nil))); err != nil {
+			return err
+		}
+		row := make([]byte, 4*dim)
+		for i := range idx.Documents {
+			for j, v := range idx.embedding(i) {
+				binary.LittleEndian.PutUint32(row[4*j:], math.Float32bits(float32(v)))
+			}
+			if _, err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	return nil
+}
+
+// Load loads an index from disk with every embedding decoded into
+// Document.Embedding. Both the binary format and the legacy JSON format are
+// accepted.
+func Load(path string) (*Index, error) {
+	idx, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	idx.materialize()
+	return idx, nil
+}
+
+// Open opens an index for searching. Binary indexes are memory-mapped where
+// the platform allows it, so only the pages touched by a search are read and
+// Document.Embedding stays nil until the index is modified (use Embedding to
+// read a vector). Legacy JSON indexes are read fully. Call Close to release
+// the mapping.
+func Open(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	defer f.Close()
+
+	var head [4]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	if string(head[:]) != magic {
+		if bytes.HasPrefix(bytes.TrimLeft(head[:], " \t\r\n"), []byte("{")) {
+			return loadJSON(path)
+		}
+		return nil, ErrFormat
+	}
+
+	data, unmap, err := mapFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("map index: %w", err)
+	}
+	idx, err := decodeBinary(path, data)
+	if err != nil {
+		_ = unmap()
+		return nil, err
+	}
+	idx.unmap = unmap
+	return idx, nil
+}
+
+// Close releases the memory mapping of an index returned by Open. The index
+// must not be searched afterwards unless it was modified first.
+func (idx *Index) Close() error {
+	if idx.unmap == nil {
+		return nil
+	}
+	unmap := idx.unmap
+	idx.unmap, idx.vectors, idx.dim = nil, nil, 0
+	return unmap()
+}
+
+// loadJSON reads the original pretty-printed JSON index format.
+func loadJSON(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("unmarshal index: %w", err)
+	}
+	return &idx, nil
+}
+
+func encodeHeader(dim, count int, model string, docsSum []byte) []byte {
+	b := make([]byte, 0, 64)
+	b = append(b, magic...)
+	b = binary.LittleEndian.AppendUint32(b, FormatVersion)
+	b = binary.LittleEndian.AppendUint32(b, uint32(dim))
+	b = binary.LittleEndian.AppendUint64(b, uint64(count))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(model)))
+	b = append(b, model...)
+	b = append(b, docsSum...)
+	for len(b)%headerAlign != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func decodeBinary(path string, data []byte) (*Index, error) {
+	const fixed = 4 + 4 + 4 + 8 + 4
+	if len(data) < fixed {
+		return nil, fmt.Errorf("%w: truncated header", ErrFormat)
+	}
+	version := binary.LittleEndian.Uint32(data[4:])
+	if version != 1 && version != FormatVersion {
+		return nil, fmt.Errorf("%w: version %d (this build reads versions 1 to %d)", ErrFormat, version, FormatVersion)
+	}
+	dim := int(binary.LittleEndian.Uint32(data[8:]))
+	count := binary.LittleEndian.Uint64(data[12:])
+	mlen := int(binary.LittleEndian.Uint32(data[20:]))
+	if fixed+mlen > len(data) {
+		return nil, fmt.Errorf("%w: truncated header", ErrFormat)
+	}
+	model := string(data[fixed : fixed+mlen])
+	off := fixed + mlen
+	var docsSum []byte // version 1 has none
+	if version >= 2 {
+		if off+sha256.Size > len(data) {
+			return nil, fmt.Errorf("%w: truncated header", ErrFormat)
+		}
+		docsSum = data[off : off+sha256.Size]
+		off += sha256.Size
+	}
+	if r := off % headerAlign; r != 0 {
+		off += headerAlign - r
+	}
+	// bound count before multiplying so a corrupt header cannot overflow
+	payload := uint64(len(data) - min(off, len(data)))
+	if dim == 0 && (count != 0 || payload != 0) ||
+		dim != 0 && (count > payload/4/uint64(dim) || payload != count*uint64(dim)*4) {
+		return nil, fmt.Errorf("%w: vector block does not match header", ErrFormat)
+	}
+
+	docs, err := readDocs(DocsPath(path), int(count), docsSum)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{
+		Documents:      docs,
+		EmbeddingModel: model,
+		vectors:        float32View(data[off:]),
+		dim:            dim,
+	}, nil
+}
+
+// readDocs reads the documents file, checking that it holds count documents
+// and, if sum is set, that it has that SHA-256.
+func readDocs(path string, count int, sum []byte) ([]Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read documents: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	docs := make([]Document, 0, count)
+	dec := json.NewDecoder(bufio.NewReader(io.TeeReader(f, h)))
+	for {
+		var rec docRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read documents: %w", err)
+		}
+		docs = append(docs, Document{ID: rec.ID, Text: rec.Text, Metadata: rec.Metadata})
+	}
+	if len(docs) != count {
+		return nil, fmt.Errorf("%w: %d documents for %d vectors", ErrFormat, len(docs), count)
+	}
+	if sum != nil && !bytes.Equal(h.Sum(nil), sum) {
+		return nil, fmt.Errorf("%w: documents file does not belong to these vectors (interrupted save?)", ErrFormat)
+	}
+	return docs, nil
+}
+
+// float32View reinterprets little-endian float32 data without copying when
+// the host byte order allows it.
+func float32View(b []byte) []float32 {
+	n := len(b) / 4
+	if n == 0 {
+		return nil
+	}
+	if littleEndianHost && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*float32)(unsafe.Pointer(&b[0])), n)
+	}
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return out
+}
+
+var littleEndianHost = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// writeAtomic writes path through a temporary file in the same directory so
+// readers (including existing memory mappings) never see a partial file.
+func writeAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
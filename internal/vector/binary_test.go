@@ -0,0 +1,191 @@
+package vector
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sampleIndex() *Index {
+	idx := NewIndex()
+	idx.EmbeddingModel = "nomic-embed-text"
+	idx.Add(Document{ID: "doc1", Text: "hello world", Embedding: []float64{1, 0, 0}, Metadata: Metadata{"source": "a.txt"}})
+	idx.Add(Document{ID: "doc2", Text: "foo bar", Embedding: []float64{0, 1, 0}})
+	idx.Add(Document{ID: "doc3", Text: "hello foo", Embedding: []float64{0.7, 0.7, 0}})
+	return idx
+}
+
+func TestBinaryOpenSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	if err := sampleIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(DocsPath(path)); err != nil {
+		t.Fatalf("documents file missing: %v", err)
+	}
+
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	if idx.EmbeddingModel != "nomic-embed-text" || idx.Count() != 3 {
+		t.Fatalf("unexpected header: model=%q count=%d", idx.EmbeddingModel, idx.Count())
+	}
+	if idx.Documents[0].Embedding != nil {
+		t.Fatal("opened index should not decode embeddings up front")
+	}
+	results, err := idx.Search([]float64{1, 0, 0}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Document.ID != "doc1" || math.Abs(results[0].Score-1) > 1e-6 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if got := results[0].Document.Embedding; len(got) != 3 || got[0] != 1 {
+		t.Fatalf("results should carry their embedding, got %v", got)
+	}
+	if results[0].Document.Metadata["source"] != "a.txt" {
+		t.Fatal("metadata not preserved")
+	}
+	if _, err := idx.Search([]float64{1, 0}, 1); err == nil {
+		t.Fatal("expected dimension mismatch error")
+	}
+}
+
+func TestBinaryAddAfterOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	if err := sampleIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(Document{ID: "doc4", Text: "new", Embedding: []float64{0, 0, 1}})
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Count() != 4 {
+		t.Fatalf("expected 4 documents, got %d", loaded.Count())
+	}
+	if e := loaded.Documents[1].Embedding; len(e) != 3 || e[1] != 1 {
+		t.Fatalf("embedding lost after reopen: %v", e)
+	}
+}
+
+func TestLoadLegacyJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	b, err := json.MarshalIndent(sampleIndex(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Count() != 3 || idx.Documents[2].Embedding[0] != 0.7 {
+		t.Fatalf("legacy index not read: %+v", idx.Documents)
+	}
+}
+
+func TestBinaryRejectsUnknownVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	if err := sampleIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	b[4] = 99
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrFormat) {
+		t.Fatalf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestBinaryRejectsInconsistentSizes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	if err := sampleIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	model := "nomic-embed-text"
+	head := len(encodeHeader(3, 3, model, make([]byte, 32)))
+	sum := b[24+len(model) : 24+len(model)+32]
+	for name, h := range map[string][]byte{
+		"zero dimension": encodeHeader(0, 3, model, sum),
+		"huge dimension": encodeHeader(math.MaxUint32, 3, model, sum),
+	} {
+		if _, err := decodeBinary(path, append(h, b[head:]...)); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: expected ErrFormat, got %v", name, err)
+		}
+		if _, err := decodeBinary(path, h); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s without vectors: expected ErrFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestBinaryRejectsDocumentsFromAnotherSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.bin")
+	if err := sampleIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	// a crash after the documents file of a later save was renamed into
+	// place but before its vectors were: same count, different documents
+	later := sampleIndex()
+	later.Documents[0].Text = "goodbye world"
+	other := filepath.Join(dir, "other.bin")
+	if err := later.Save(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(DocsPath(other), DocsPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrFormat) {
+		t.Fatalf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestBinaryReadsVersion1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	if err := sampleIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	// rewrite the header without the documents checksum
+	b, _ := os.ReadFile(path)
+	v2 := encodeHeader(3, 3, "nomic-embed-text", make([]byte, 32))
+	v1 := encodeHeader(3, 3, "nomic-embed-text", nil)
+	v1[4] = 1
+	if err := os.WriteFile(path, append(v1, b[len(v2):]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Count() != 3 || idx.Documents[2].Embedding[0] != float64(float32(0.7)) {
+		t.Fatalf("version 1 index not read: %+v", idx.Documents)
+	}
+}
+
+func TestSaveRejectsMixedDimensions(t *testing.T) {
+	idx := sampleIndex()
+	idx.Add(Document{ID: "bad", Embedding: []float64{1, 2}})
+	if err := idx.Save(filepath.Join(t.TempDir(), "index.bin")); err == nil {
+		t.Fatal("expected dimension error")
+	}
+}
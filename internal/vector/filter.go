@@ -0,0 +1,349 @@
+package vector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed metadata filter expression. A nil *Filter matches every
+// document.
+//
+// An expression combines comparisons on metadata keys with AND, OR, NOT (or
+// &&, ||, !) and parentheses; AND binds tighter than OR:
+//
+//	kind=pdf                     equal (numerically when both sides are numbers)
+//	kind!=pdf                    not equal
+//	kind in (pdf, markdown)      one of a set
+//	source^=/home/me/2024/       prefix
+//	source~=/reports/            contains
+//	source$=.pdf                 suffix
+//	chunkIdx>=2 AND chunkIdx<10  numeric range (strings compare lexically,
+//	                             which orders ISO dates correctly)
+//
+// Values are bare words or quoted with " or '. A comparison on a key the
+// document does not have is false, except for !=.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter parses a filter expression. An empty expression gives a nil
+// filter.
+func ParseFilter(expr string) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	p := &filterParser{s: expr}
+	root, err := p.or()
+	if err == nil && !p.eof() {
+		err = p.errorf("unexpected %q", p.rest())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether the metadata satisfies the filter.
+func (f *Filter) Match(md Metadata) bool {
+	return f == nil || f.root.match(md)
+}
+
+// String returns the expression the filter was parsed from.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+type filterNode interface {
+	match(md Metadata) bool
+}
+
+type andNode struct{ l, r filterNode }
+
+func (n andNode) match(md Metadata) bool { return n.l.match(md) && n.r.match(md) }
+
+type orNode struct{ l, r filterNode }
+
+func (n orNode) match(md Metadata) bool { return n.l.match(md) || n.r.match(md) }
+
+type notNode struct{ n filterNode }
+
+func (n notNode) match(md Metadata) bool { return !n.n.match(md) }
+
+type cmpNode struct {
+	key    string
+	op     string
+	values []string
+}
+
+func (n cmpNode) match(md Metadata) bool {
+	v, ok := md[n.key]
+	if !ok || v == nil {
+		return n.op == "!="
+	}
+	s := metaString(v)
+	switch n.op {
+	case "=":
+		return compare(s, n.values[0]) == 0
+	case "!=":
+		return compare(s, n.values[0]) != 0
+	case "in":
+		for _, want := range n.values {
+			if compare(s, want) == 0 {
+				return true
+			}
+		}
+		return false
+	case "^=":
+		return strings.HasPrefix(s, n.values[0])
+	case "~=":
+		return strings.Contains(s, n.values[0])
+	case "$=":
+		return strings.HasSuffix(s, n.values[0])
+	case "<":
+		return compare(s, n.values[0]) < 0
+	case "<=":
+		return compare(s, n.values[0]) <= 0
+	case ">":
+		return compare(s, n.values[0]) > 0
+	case ">=":
+		return compare(s, n.values[0]) >= 0
+	}
+	return false
+}
+
+// metaString renders a metadata value the way it is written in filters.
+func metaString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compare orders a and b numerically when both are numbers and lexically
+// otherwise.
+func compare(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// filterParser is a recursive-descent parser over the expression text.
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") || p.symbol("||") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") || p.symbol("&&") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) unary() (filterNode, error) {
+	if p.keyword("not") || (!strings.HasPrefix(p.rest(), "!=") && p.symbol("!")) {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	if p.symbol("(") {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, p.errorf("missing )")
+		}
+		return n, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterNode, error) {
+	key := p.key()
+	if key == "" {
+		if p.eof() {
+			return nil, p.errorf("unexpected end of expression")
+		}
+		return nil, p.errorf("expected a metadata key at %q", p.rest())
+	}
+
+	negate := p.keyword("not")
+	if p.keyword("in") {
+		values, err := p.set()
+		if err != nil {
+			return nil, err
+		}
+		var n filterNode = cmpNode{key: key, op: "in", values: values}
+		if negate {
+			n = notNode{n}
+		}
+		return n, nil
+	}
+	if negate {
+		return nil, p.errorf("expected in after %s not", key)
+	}
+
+	for _, op := range []string{"!=", "^=", "~=", "$=", "<=", ">=", "=", "<", ">"} {
+		if p.symbol(op) {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			return cmpNode{key: key, op: op, values: []string{v}}, nil
+		}
+	}
+	return nil, p.errorf("expected an operator after %s", key)
+}
+
+func (p *filterParser) set() ([]string, error) {
+	if !p.symbol("(") {
+		return nil, p.errorf("expected ( after in")
+	}
+	var values []string
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if p.symbol(")") {
+			return values, nil
+		}
+		if !p.symbol(",") {
+			return nil, p.errorf("expected , or ) in set")
+		}
+	}
+}
+
+// key reads a metadata key: letters, digits, '_', '-' and '.'.
+func (p *filterParser) key() string {
+	p.space()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := rune(p.s[p.pos])
+		if !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' || c == '.') {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// value reads a quoted string or a bare word, which ends at whitespace, a
+// parenthesis or a comma.
+func (p *filterParser) value() (string, error) {
+	p.space()
+	if p.eof() {
+		return "", p.errorf("missing value")
+	}
+	if q := p.s[p.pos]; q == '"' || q == '\'' {
+		end := strings.IndexByte(p.s[p.pos+1:], q)
+		if end < 0 {
+			return "", p.errorf("unterminated quote")
+		}
+		v := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return v, nil
+	}
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n(),", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("missing value at %q", p.rest())
+	}
+	return p.s[start:p.pos], nil
+}
+
+// keyword consumes a case-insensitive word followed by a non-key character.
+func (p *filterParser) keyword(w string) bool {
+	p.space()
+	end := p.pos + len(w)
+	if end > len(p.s) || !strings.EqualFold(p.s[p.pos:end], w) {
+		return false
+	}
+	if end < len(p.s) {
+		c := rune(p.s[end])
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' {
+			return false
+		}
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterParser) symbol(sym string) bool {
+	p.space()
+	if strings.HasPrefix(p.s[p.pos:], sym) {
+		p.pos += len(sym)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) space() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *filterParser) eof() bool {
+	p.space()
+	return p.pos >= len(p.s)
+}
+
+func (p *filterParser) rest() string {
+	return p.s[p.pos:]
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,68 @@
+package vector
+
+import "testing"
+
+func TestFilterMatch(t *testing.T) {
+	md := Metadata{
+		"source":   "/home/me/reports/2024/q1.pdf",
+		"kind":     "pdf",
+		"chunkIdx": float64(3),
+		"heading":  "Results > Revenue",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"kind=pdf", true},
+		{"kind = 'pdf'", true},
+		{"kind!=pdf", false},
+		{"kind in (markdown, pdf)", true},
+		{"kind not in (markdown, text)", true},
+		{"source^=/home/me/reports/", true},
+		{"source~=/reports/ AND kind=pdf", true},
+		{"source$=.txt", false},
+		{"chunkIdx=3", true},
+		{"chunkIdx=3.0", true},
+		{"chunkIdx>=2 and chunkIdx<3", false},
+		{"chunkIdx>=2 && chunkIdx<10", true},
+		{"kind=text OR chunkIdx>2", true},
+		{"kind=text OR chunkIdx>2 AND kind=markdown", false},
+		{"(kind=text OR chunkIdx>2) AND NOT kind=markdown", true},
+		{"!kind=pdf", false},
+		{`heading="Results > Revenue"`, true},
+		{"missing=x", false},
+		{"missing!=x", true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %v", tt.expr, err)
+		}
+		if got := f.Match(md); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"kind",
+		"kind=",
+		"kind=pdf AND",
+		"(kind=pdf",
+		"kind in pdf",
+		"kind in (pdf",
+		"kind not pdf",
+		"kind='pdf",
+		"=pdf",
+		"kind=pdf extra",
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q): expected error", expr)
+		}
+	}
+	f, err := ParseFilter("  ")
+	if err != nil || f != nil || !f.Match(Metadata{}) {
+		t.Fatalf("empty filter: %v %v", f, err)
+	}
+}
@@ -0,0 +1,674 @@
+package vector
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HNSWConfig tunes a Hierarchical Navigable Small World graph.
+type HNSWConfig struct {
+	M              int `json:"m"`              // links per node and layer (2*M on layer 0)
+	EfConstruction int `json:"efConstruction"` // candidate list size while inserting
+	EfSearch       int `json:"efSearch"`       // candidate list size while searching (at least topK)
+}
+
+// DefaultHNSWConfig returns settings that give high recall for typical
+// embedding sizes.
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{M: 16, EfConstruction: 200, EfSearch: 64}
+}
+
+func (c HNSWConfig) withDefaults() HNSWConfig {
+	d := DefaultHNSWConfig()
+	if c.M < 2 {
+		c.M = d.M
+	}
+	if c.EfConstruction <= 0 {
+		c.EfConstruction = d.EfConstruction
+	}
+	if c.EfSearch <= 0 {
+		c.EfSearch = d.EfSearch
+	}
+	return c
+}
+
+// HNSW is an approximate nearest neighbour index over cosine similarity. It
+// keeps its documents in an Index, so Save also writes the regular index
+// files, and adds a graph file next to them (see GraphPath).
+type HNSW struct {
+	cfg  HNSWConfig
+	docs *Index
+
+	dim      int
+	vecs     []float32 // normalized vectors, dim per node
+	nodes    [][][]int32
+	entry    int
+	maxLevel int
+	rng      *rand.Rand
+
+	// deleted marks tombstoned nodes: they still route searches but are
+	// never returned, and Save rebuilds the graph without them.
+	deleted  []bool
+	nDeleted int
+}
+
+// NewHNSW creates an empty HNSW index.
+func NewHNSW(cfg HNSWConfig) *HNSW {
+	return &HNSW{cfg: cfg.withDefaults(), docs: NewIndex(), entry: -1, rng: rand.New(rand.NewPCG(1, 2))}
+}
+
+// Config returns the graph parameters in use.
+func (h *HNSW) Config() HNSWConfig {
+	return h.cfg
+}
+
+// SetEfSearch changes the search candidate list size of a built index.
+func (h *HNSW) SetEfSearch(ef int) {
+	if ef > 0 {
+		h.cfg.EfSearch = ef
+	}
+}
+
+// Count returns the number of documents in the index
+func (h *HNSW) Count() int {
+	return h.docs.Count() - h.nDeleted
+}
+
+// Delete tombstones the documents with the given IDs.
+func (h *HNSW) Delete(ids ...string) int {
+	n := 0
+	for _, id := range ids {
+		if i, ok := h.docs.position(id); ok && !h.deleted[i] {
+			h.deleted[i] = true
+			n++
+		}
+	}
+	h.nDeleted += n
+	return n
+}
+
+// Get returns the document with the given ID.
+func (h *HNSW) Get(id string) (Document, bool) {
+	i, ok := h.docs.position(id)
+	if !ok || h.deleted[i] {
+		return Document{}, false
+	}
+	return h.docs.Get(id)
+}
+
+// IDs returns the IDs of all documents in insertion order.
+func (h *HNSW) IDs() []string {
+	ids := make([]string, 0, h.Count())
+	for i, doc := range h.docs.Documents {
+		if !h.deleted[i] {
+			ids = append(ids, doc.ID)
+		}
+	}
+	return ids
+}
+
+// SetEmbeddingModel records the model that produced the embeddings.
+func (h *HNSW) SetEmbeddingModel(name string) {
+	h.docs.EmbeddingModel = name
+}
+
+// Close is a no-op; an HNSW index holds no external resources.
+func (h *HNSW) Close() error {
+	return nil
+}
+
+// Stats describes the index.
+func (h *HNSW) Stats() Stats {
+	return Stats{
+		Backend:        BackendHNSW,
+		Documents:      h.Count(),
+		Dimension:      h.dim,
+		EmbeddingModel: h.docs.EmbeddingModel,
+		Params: map[string]int{
+			"m":              h.cfg.M,
+			"efConstruction": h.cfg.EfConstruction,
+			"efSearch":       h.cfg.EfSearch,
+			"levels":         h.maxLevel + 1,
+		},
+	}
+}
+
+// compact drops tombstoned nodes. While they are few, they are unlinked in
+// place; once they make up more than a quarter of the graph, it is rebuilt.
+func (h *HNSW) compact() {
+	if h.nDeleted == 0 {
+		return
+	}
+	if 4*h.nDeleted > len(h.nodes) {
+		h.rebuild()
+		return
+	}
+	h.unlink()
+}
+
+// unlink removes tombstoned nodes from the graph. A node that linked to one
+// is relinked among its remaining links and the deleted node's neighbours,
+// so the graph stays connected without reinserting anything.
+func (h *HNSW) unlink() {
+	for n, levels := range h.nodes {
+		if h.deleted[n] {
+			continue
+		}
+		for l, links := range levels {
+			lost := false
+			seen := map[int32]bool{int32(n): true}
+			var cands []candidate
+			add := func(m int32) {
+				if !h.deleted[m] && !seen[m] {
+					seen[m] = true
+					cands = append(cands, candidate{id: m, sim: h.sim(h.vec(n), int(m))})
+				}
+			}
+			for _, m := range links {
+				if h.deleted[m] {
+					lost = true
+					for _, k := range h.nodes[m][l] {
+						add(k)
+					}
+				} else {
+					add(m)
+				}
+			}
+			if !lost {
+				continue
+			}
+			sort.Slice(cands, func(i, j int) bool { return cands[i].sim > cands[j].sim })
+			limit := h.cfg.M
+			if l == 0 {
+				limit = 2 * h.cfg.M
+			}
+			h.nodes[n][l] = h.selectNeighbours(cands, limit)
+		}
+	}
+
+	if h.entry >= 0 && h.deleted[h.entry] {
+		h.entry, h.maxLevel = -1, 0
+		for i, levels := range h.nodes {
+			if !h.deleted[i] && !isZero(h.vec(i)) && (h.entry < 0 || len(levels)-1 > h.maxLevel) {
+				h.entry, h.maxLevel = i, len(levels)-1
+			}
+		}
+	}
+
+	// renumber the remaining nodes
+	remap := make([]int32, len(h.nodes))
+	next := int32(0)
+	for i := range h.nodes {
+		if h.deleted[i] {
+			remap[i] = -1
+		} else {
+			remap[i] = next
+			next++
+		}
+	}
+	nodes, vecs := h.nodes[:0], h.vecs[:0]
+	for i, levels := range h.nodes {
+		if h.deleted[i] {
+			continue
+		}
+		for _, links := range levels {
+			for j, m := range links {
+				links[j] = remap[m]
+			}
+		}
+		nodes = append(nodes, levels)
+		vecs = append(vecs, h.vec(i)...)
+	}
+	h.docs.filter(func(i int) bool { return !h.deleted[i] })
+	if h.entry >= 0 {
+		h.entry = int(remap[h.entry])
+	}
+	h.nodes, h.vecs = nodes, vecs
+	h.deleted, h.nDeleted = make([]bool, len(nodes)), 0
+}
+
+// rebuild builds the graph again from the documents that are not deleted.
+func (h *HNSW) rebuild() {
+	docs := make([]Document, 0, h.Count())
+	for i, doc := range h.docs.Documents {
+		if !h.deleted[i] {
+			doc.Embedding = h.docs.Embedding(i)
+			docs = append(docs, doc)
+		}
+	}
+	fresh := NewHNSW(h.cfg)
+	fresh.docs.EmbeddingModel = h.docs.EmbeddingModel
+	for _, doc := range docs {
+		fresh.Add(doc)
+	}
+	*h = *fresh
+}
+
+// Add inserts a document into the graph. Documents whose embedding dimension
+// differs from the first document's are kept but are not searchable.
+func (h *HNSW) Add(doc Document) {
+	h.docs.Add(doc)
+	h.insert(doc.Embedding)
+}
+
+func (h *HNSW) insert(embedding []float64) {
+	id := len(h.nodes)
+	if h.dim == 0 {
+		h.dim = len(embedding)
+	}
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) / math.Log(float64(h.cfg.M))))
+	h.nodes = append(h.nodes, make([][]int32, level+1))
+	h.deleted = append(h.deleted, false)
+	h.vecs = append(h.vecs, normalize(embedding, h.dim)...)
+	if len(embedding) != h.dim || isZero(h.vec(id)) {
+		return // not reachable from the graph
+	}
+
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return
+	}
+
+	q := h.vec(id)
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		cands := h.searchLayer(q, ep, h.cfg.EfConstruction, l)
+		neighbours := h.selectNeighbours(cands, h.cfg.M)
+		h.nodes[id][l] = neighbours
+		for _, n := range neighbours {
+			h.link(int(n), int32(id), l)
+		}
+		ep = int(cands[0].id)
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// link adds a connection from node n to id on layer l, pruning n's links
+// when it has too many.
+func (h *HNSW) link(n int, id int32, l int) {
+	links := append(h.nodes[n][l], id)
+	limit := h.cfg.M
+	if l == 0 {
+		limit = 2 * h.cfg.M
+	}
+	if len(links) > limit {
+		cands := make([]candidate, len(links))
+		for i, m := range links {
+			cands[i] = candidate{id: m, sim: h.sim(h.vec(n), int(m))}
+		}
+		sort.Slice(cands, func(i, j int) bool { return cands[i].sim > cands[j].sim })
+		links = h.selectNeighbours(cands, limit)
+	}
+	h.nodes[n][l] = links
+}
+
+// selectNeighbours applies the HNSW neighbour heuristic to candidates sorted
+// by decreasing similarity: a candidate is kept only if it is closer to the
+// base than to any neighbour kept so far, which spreads links in different
+// directions. Remaining slots are filled with the closest pruned candidates.
+func (h *HNSW) selectNeighbours(cands []candidate, m int) []int32 {
+	out := make([]int32, 0, m)
+	var pruned []int32
+	for _, c := range cands {
+		if len(out) >= m {
+			break
+		}
+		keep := true
+		for _, o := range out {
+			if h.sim(h.vec(int(c.id)), int(o)) > c.sim {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, c.id)
+		} else {
+			pruned = append(pruned, c.id)
+		}
+	}
+	for _, p := range pruned {
+		if len(out) >= m {
+			break
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// greedy walks layer l towards q and returns the closest node found.
+func (h *HNSW) greedy(q []float32, ep, l int) int {
+	best := h.sim(q, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.nodes[ep][l] {
+			if s := h.sim(q, int(n)); s > best {
+				best, ep, changed = s, int(n), true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns up to ef nodes of layer l closest to q, best first.
+func (h *HNSW) searchLayer(q []float32, ep, ef, l int) []candidate {
+	visited := map[int32]bool{int32(ep): true}
+	start := candidate{id: int32(ep), sim: h.sim(q, ep)}
+	cands := &maxHeap{start}
+	found := &minHeap{start}
+
+	for cands.Len() > 0 {
+		c := heap.Pop(cands).(candidate)
+		if found.Len() >= ef && c.sim < (*found)[0].sim {
+			break
+		}
+		for _, n := range h.nodes[c.id][l] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			s := h.sim(q, int(n))
+			if found.Len() < ef || s > (*found)[0].sim {
+				heap.Push(cands, candidate{id: n, sim: s})
+				heap.Push(found, candidate{id: n, sim: s})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	out := make([]candidate, found.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(found).(candidate)
+	}
+	return out
+}
+
+// Search returns the approximate top-k documents by cosine similarity.
+func (h *HNSW) Search(queryEmbedding []float64, topK int) ([]SearchResult, error) {
+	return h.SearchFilter(queryEmbedding, topK, nil)
+}
+
+// SearchFilter is Search over the documents whose metadata matches f. The
+// graph is still walked through non-matching nodes; the candidate list is
+// widened until topK matches are found or the whole graph has been seen.
+func (h *HNSW) SearchFilter(queryEmbedding []float64, topK int, f *Filter) ([]SearchResult, error) {
+	if h.entry < 0 || topK <= 0 {
+		return []SearchResult{}, nil
+	}
+	if len(queryEmbedding) != h.dim {
+		return nil, fmt.Errorf("vector dimensions don't match: %d vs %d", len(queryEmbedding), h.dim)
+	}
+	q := normalize(queryEmbedding, h.dim)
+	if isZero(q) {
+		return nil, errors.New("cannot compute similarity: query vector is zero")
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	var cands []candidate
+	for ef := max(h.cfg.EfSearch, topK); ; ef *= 2 {
+		cands = cands[:0]
+		for _, c := range h.searchLayer(q, ep, ef, 0) {
+			if !h.deleted[c.id] && f.Match(h.docs.Documents[c.id].Metadata) {
+				cands = append(cands, c)
+			}
+		}
+		// widen the search when tombstones or the filter crowd out results
+		if len(cands) >= topK || (h.nDeleted == 0 && f == nil) || ef >= len(h.nodes) {
+			break
+		}
+	}
+	if len(cands) > topK {
+		cands = cands[:topK]
+	}
+
+	results := make([]SearchResult, len(cands))
+	for i, c := range cands {
+		doc := h.docs.Documents[c.id]
+		doc.Embedding = h.docs.Embedding(int(c.id))
+		results[i] = SearchResult{Document: doc, Score: c.sim}
+	}
+	return results, nil
+}
+
+func (h *HNSW) vec(i int) []float32 {
+	return h.vecs[i*h.dim : (i+1)*h.dim]
+}
+
+func (h *HNSW) sim(q []float32, i int) float64 {
+	var dot float32
+	for j, v := range h.vec(i) {
+		dot += q[j] * v
+	}
+	return float64(dot)
+}
+
+func normalize(v []float64, dim int) []float32 {
+	out := make([]float32, dim)
+	if len(v) != dim {
+		return out
+	}
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	if norm == 0 {
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, x := range v {
+		out[i] = float32(x / norm)
+	}
+	return out
+}
+
+func isZero(v []float32) bool {
+	for _, x := range v {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type candidate struct {
+	id  int32
+	sim float64
+}
+
+// maxHeap pops the most similar candidate first.
+type maxHeap []candidate
+
+func (h maxHeap) Len() int           { return len(h) }
+func (h maxHeap) Less(i, j int) bool { return h[i].sim > h[j].sim }
+func (h maxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// minHeap pops the least similar candidate first.
+type minHeap []candidate
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].sim < h[j].sim }
+func (h minHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// Graph file layout (little-endian): magic "OCNH", version uint32, M,
+// efConstruction, efSearch uint32, entry int32, maxLevel uint32, count uint64,
+// then per node: level count uint8 and per level a uint32 link count followed
+// by the int32 links.
+const graphMagic = "OCNH"
+
+// GraphPath returns the graph file that accompanies the index at path.
+func GraphPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".hnsw"
+}
+
+// Save writes the documents and vectors to path (as Index.Save does) and the
+// graph to GraphPath(path). Deleted documents are dropped by rebuilding the
+// graph first.
+func (h *HNSW) Save(path string) error {
+	h.compact()
+	if err := h.docs.Save(path); err != nil {
+		return err
+	}
+	err := writeAtomic(GraphPath(path), func(w io.Writer) error {
+		b := []byte(graphMagic)
+		b = binary.LittleEndian.AppendUint32(b, sidecarVersion)
+		b = binary.LittleEndian.AppendUint32(b, uint32(h.cfg.M))
+		b = binary.LittleEndian.AppendUint32(b, uint32(h.cfg.EfConstruction))
+		b = binary.LittleEndian.AppendUint32(b, uint32(h.cfg.EfSearch))
+		b = binary.LittleEndian.AppendUint32(b, uint32(int32(h.entry)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(h.maxLevel))
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(h.nodes)))
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		for _, levels := range h.nodes {
+			b = append(b[:0], byte(len(levels)))
+			for _, links := range levels {
+				b = binary.LittleEndian.AppendUint32(b, uint32(len(links)))
+				for _, n := range links {
+					b = binary.LittleEndian.AppendUint32(b, uint32(n))
+				}
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("write graph: %w", err)
+	}
+	return nil
+}
+
+// OpenHNSW loads an HNSW index written by Save. When the graph file is
+// missing (e.g. the model was built with the flat index) or unreadable the
+// graph is built from the stored vectors with cfg.
+func OpenHNSW(path string, cfg HNSWConfig) (*HNSW, error) {
+	docs, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	build := func() *HNSW {
+		h := NewHNSW(cfg)
+		h.docs.EmbeddingModel = docs.EmbeddingModel
+		for _, doc := range docs.Documents {
+			h.Add(doc)
+		}
+		return h
+	}
+	f, err := os.Open(GraphPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return build(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read graph: %w", err)
+	}
+	defer f.Close()
+
+	h, err := readGraph(bufio.NewReader(f), docs)
+	if errors.Is(err, ErrFormat) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return build(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read graph: %w", err)
+	}
+	if cfg.EfSearch > 0 {
+		h.cfg.EfSearch = cfg.EfSearch
+	}
+	return h, nil
+}
+
+func readGraph(r io.Reader, docs *Index) (*HNSW, error) {
+	var head [4 + 4*6 + 8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if string(head[:4]) != graphMagic {
+		return nil, ErrFormat
+	}
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(head[4+4*i:]) }
+	if u32(0) != sidecarVersion {
+		return nil, fmt.Errorf("%w: graph version %d", ErrFormat, u32(0))
+	}
+	count := binary.LittleEndian.Uint64(head[28:])
+	if count != uint64(docs.Count()) {
+		return nil, fmt.Errorf("%w: graph has %d nodes for %d documents", ErrFormat, count, docs.Count())
+	}
+
+	h := &HNSW{
+		cfg:      HNSWConfig{M: int(u32(1)), EfConstruction: int(u32(2)), EfSearch: int(u32(3))}.withDefaults(),
+		docs:     docs,
+		entry:    int(int32(u32(4))),
+		maxLevel: int(u32(5)),
+		nodes:    make([][][]int32, count),
+		rng:      rand.New(rand.NewPCG(1, 2)),
+		deleted:  make([]bool, count),
+	}
+	for i := range docs.Documents {
+		if h.dim == 0 {
+			h.dim = len(docs.Documents[i].Embedding)
+		}
+		h.vecs = append(h.vecs, normalize(docs.Documents[i].Embedding, h.dim)...)
+	}
+
+	var buf [4]byte
+	for i := range h.nodes {
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return nil, err
+		}
+		h.nodes[i] = make([][]int32, buf[0])
+		for l := range h.nodes[i] {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, err
+			}
+			links := make([]int32, binary.LittleEndian.Uint32(buf[:]))
+			for j := range links {
+				if _, err := io.ReadFull(r, buf[:]); err != nil {
+					return nil, err
+				}
+				links[j] = int32(binary.LittleEndian.Uint32(buf[:]))
+				if links[j] < 0 || uint64(links[j]) >= count {
+					return nil, fmt.Errorf("%w: link out of range", ErrFormat)
+				}
+			}
+			h.nodes[i][l] = links
+		}
+	}
+	if h.entry != -1 && (h.entry < 0 || uint64(h.entry) >= count || h.maxLevel >= len(h.nodes[h.entry])) {
+		return nil, fmt.Errorf("%w: entry point out of range", ErrFormat)
+	}
+	return h, nil
+}
@@ -0,0 +1,244 @@
+package vector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randomDocs(n, dim int, seed uint64) []Document {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	docs := make([]Document, n)
+	for i := range docs {
+		e := make([]float64, dim)
+		for j := range e {
+			e[j] = rng.NormFloat64()
+		}
+		docs[i] = Document{ID: fmt.Sprintf("doc%d", i), Embedding: e}
+	}
+	return docs
+}
+
+// recall returns the fraction of the brute-force top-k found by an
+// approximate index.
+func recall(t testing.TB, flat *Index, h Store, queries []Document, k int) float64 {
+	hit, total := 0, 0
+	for _, q := range queries {
+		want, err := flat.Search(q.Embedding, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := h.Search(q.Embedding, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := map[string]bool{}
+		for _, r := range got {
+			ids[r.Document.ID] = true
+		}
+		for _, r := range want {
+			if ids[r.Document.ID] {
+				hit++
+			}
+			total++
+		}
+	}
+	return float64(hit) / float64(total)
+}
+
+func buildBoth(docs []Document, cfg HNSWConfig) (*Index, *HNSW) {
+	flat, h := NewIndex(), NewHNSW(cfg)
+	for _, d := range docs {
+		flat.Add(d)
+		h.Add(d)
+	}
+	return flat, h
+}
+
+func TestHNSWRecall(t *testing.T) {
+	flat, h := buildBoth(randomDocs(2000, 32, 1), DefaultHNSWConfig())
+	queries := randomDocs(50, 32, 2)
+	if r := recall(t, flat, h, queries, 10); r < 0.95 {
+		t.Fatalf("recall@10 = %.3f, want >= 0.95", r)
+	}
+}
+
+func TestHNSWSearchContract(t *testing.T) {
+	h := NewHNSW(DefaultHNSWConfig())
+	results, err := h.Search([]float64{1, 0, 0}, 5)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("empty index: %v %v", results, err)
+	}
+	h.Add(Document{ID: "doc1", Text: "hello world", Embedding: []float64{1, 0, 0}, Metadata: Metadata{"source": "a.txt"}})
+	h.Add(Document{ID: "doc2", Text: "foo bar", Embedding: []float64{0, 1, 0}})
+	h.Add(Document{ID: "doc3", Text: "hello foo", Embedding: []float64{0.7, 0.7, 0}})
+
+	results, err = h.Search([]float64{1, 0, 0}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Document.ID != "doc1" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i-1].Score < results[i].Score {
+			t.Fatal("results not sorted by score descending")
+		}
+	}
+	if results[0].Document.Metadata["source"] != "a.txt" || len(results[0].Document.Embedding) != 3 {
+		t.Fatal("document not returned in full")
+	}
+	if _, err := h.Search([]float64{1, 0}, 1); err == nil {
+		t.Fatal("expected dimension mismatch error")
+	}
+}
+
+func TestHNSWPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	flat, h := buildBoth(randomDocs(500, 16, 3), HNSWConfig{M: 8, EfConstruction: 100, EfSearch: 50})
+	if err := h.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := OpenHNSW(path, HNSWConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Config().M != 8 || loaded.Count() != 500 {
+		t.Fatalf("unexpected loaded index: %+v count=%d", loaded.Config(), loaded.Count())
+	}
+	queries := randomDocs(20, 16, 4)
+	for _, q := range queries {
+		a, _ := h.Search(q.Embedding, 5)
+		b, _ := loaded.Search(q.Embedding, 5)
+		for i := range a {
+			if a[i].Document.ID != b[i].Document.ID {
+				t.Fatalf("reloaded graph gives different results")
+			}
+		}
+	}
+
+	// the flat index files alone are enough to rebuild a graph
+	flatPath := filepath.Join(t.TempDir(), "flat.bin")
+	if err := flat.Save(flatPath); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := OpenHNSW(flatPath, HNSWConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := recall(t, flat, rebuilt, queries, 5); r < 0.9 {
+		t.Fatalf("rebuilt graph recall@5 = %.3f", r)
+	}
+	if _, err := OpenHNSW(filepath.Join(t.TempDir(), "missing.bin"), HNSWConfig{}); err == nil {
+		t.Fatal("expected error for missing index")
+	}
+}
+
+func TestHNSWCorruptGraph(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	flat, h := buildBoth(randomDocs(200, 8, 8), HNSWConfig{M: 8, EfConstruction: 100, EfSearch: 50})
+	if err := h.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(GraphPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string]func(b []byte){
+		"entry":     func(b []byte) { binary.LittleEndian.PutUint32(b[20:], 200) },
+		"max level": func(b []byte) { binary.LittleEndian.PutUint32(b[24:], 50) },
+	} {
+		b := append([]byte(nil), good...)
+		corrupt(b)
+		if _, err := readGraph(bytes.NewReader(b), flat); !errors.Is(err, ErrFormat) {
+			t.Fatalf("%s: expected ErrFormat, got %v", name, err)
+		}
+		// opening rebuilds the graph rather than failing later in Search
+		if err := os.WriteFile(GraphPath(path), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := OpenHNSW(path, HNSWConfig{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if r := recall(t, flat, loaded, randomDocs(10, 8, 9), 5); r < 0.9 {
+			t.Fatalf("%s: rebuilt graph recall@5 = %.3f", name, r)
+		}
+	}
+}
+
+func TestHNSWSaveDropsDeleted(t *testing.T) {
+	queries := randomDocs(30, 16, 7)
+	// a few tombstones are unlinked in place, many rebuild the graph
+	for _, n := range []int{20, 400} {
+		flat, h := buildBoth(randomDocs(1000, 16, 6), HNSWConfig{M: 8, EfConstruction: 100, EfSearch: 50})
+		var ids []string
+		for i := range n {
+			ids = append(ids, fmt.Sprintf("doc%d", i*1000/n))
+		}
+		flat.Delete(ids...)
+		h.Delete(ids...)
+
+		path := filepath.Join(t.TempDir(), "index.bin")
+		if err := h.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := OpenHNSW(path, HNSWConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Count() != 1000-n || len(loaded.IDs()) != 1000-n {
+			t.Fatalf("%d deleted: %d documents left", n, loaded.Count())
+		}
+		if _, ok := loaded.Get(ids[0]); ok {
+			t.Fatalf("%d deleted: deleted document saved", n)
+		}
+		if r := recall(t, flat, loaded, queries, 10); r < 0.9 {
+			t.Fatalf("%d deleted: recall@10 = %.3f", n, r)
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	docs := randomDocs(20000, 64, 5)
+	queries := randomDocs(100, 64, 6)
+	flat, h := buildBoth(docs, DefaultHNSWConfig())
+
+	b.Run("flat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = flat.Search(queries[i%len(queries)].Embedding, 10)
+		}
+	})
+	for _, ef := range []int{16, 64, 200} {
+		b.Run(fmt.Sprintf("hnsw/ef=%d", ef), func(b *testing.B) {
+			h.SetEfSearch(ef)
+			for i := 0; i < b.N; i++ {
+				_, _ = h.Search(queries[i%len(queries)].Embedding, 10)
+			}
+			b.StopTimer()
+			b.ReportMetric(recall(b, flat, h, queries, 10), "recall@10")
+		})
+	}
+
+	ivf := NewIVF(IVFConfig{})
+	for _, d := range docs {
+		ivf.Add(d)
+	}
+	ivf.Train()
+	for _, nprobe := range []int{8, 32} {
+		b.Run(fmt.Sprintf("ivf/nprobe=%d", nprobe), func(b *testing.B) {
+			ivf.cfg.NProbe = nprobe
+			for i := 0; i < b.N; i++ {
+				_, _ = ivf.Search(queries[i%len(queries)].Embedding, 10)
+			}
+			b.StopTimer()
+			b.ReportMetric(recall(b, flat, ivf, queries, 10), "recall@10")
+		})
+	}
+}
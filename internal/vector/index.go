@@ -1,10 +1,8 @@
 package vector
 
 import (
-	"encoding/json"
 	"fmt"
 	"math"
-	"os"
 	"sort"
 )
 
@@ -28,6 +26,17 @@ type SearchResult struct {
 // Index is an in-memory vector index with disk persistence
 type Index struct {
 	Documents []Document `json:"documents"`
+	// EmbeddingModel names the model that produced the embeddings.
+	EmbeddingModel string `json:"embeddingModel,omitempty"`
+
+	// For indexes returned by Open, embeddings live in vectors (row-major,
+	// dim floats per document, possibly memory-mapped) instead of in
+	// Document.Embedding.
+	vectors []float32
+	dim     int
+	unmap   func() error
+
+	byID map[string]int // lazily built by position
 }
 
 // NewIndex creates a new empty vector index
@@ -39,9 +48,42 @@ func NewIndex() *Index {
 
 // Add adds a document to the index
 func (idx *Index) Add(doc Document) {
+	idx.materialize()
+	if idx.byID != nil {
+		idx.byID[doc.ID] = len(idx.Documents)
+	}
 	idx.Documents = append(idx.Documents, doc)
 }
 
+// Embedding returns the embedding of the i-th document.
+func (idx *Index) Embedding(i int) []float64 {
+	return idx.embedding(i)
+}
+
+func (idx *Index) embedding(i int) []float64 {
+	if idx.vectors == nil {
+		return idx.Documents[i].Embedding
+	}
+	row := idx.vectors[i*idx.dim : (i+1)*idx.dim]
+	out := make([]float64, len(row))
+	for j, v := range row {
+		out[j] = float64(v)
+	}
+	return out
+}
+
+// materialize copies mapped vectors into Document.Embedding so the index can
+// be modified, and releases the mapping.
+func (idx *Index) materialize() {
+	if idx.vectors == nil {
+		return
+	}
+	for i := range idx.Documents {
+		idx.Documents[i].Embedding = idx.embedding(i)
+	}
+	_ = idx.Close()
+}
+
 // CosineSimilarity calculates the cosine similarity between two vectors
 func CosineSimilarity(a, b []float64) (float64, error) {
 	if len(a) != len(b) {
@@ -67,64 +109,84 @@ func CosineSimilarity(a, b []float64) (float64, error) {
 
 // Search performs a cosine similarity search and returns the top-k results
 func (idx *Index) Search(queryEmbedding []float64, topK int) ([]SearchResult, error) {
+	return idx.SearchFilter(queryEmbedding, topK, nil)
+}
+
+// SearchFilter is Search restricted to documents whose metadata matches f
+func (idx *Index) SearchFilter(queryEmbedding []float64, topK int, f *Filter) ([]SearchResult, error) {
 	if len(idx.Documents) == 0 {
 		return []SearchResult{}, nil
 	}
 	
-	results := make([]SearchResult, 0, len(idx.Documents))
+	type scored struct {
+		i     int
+		score float64
+	}
+	hits := make([]scored, 0, len(idx.Documents))
 	
-	for _, doc := range idx.Documents {
-		score, err := CosineSimilarity(queryEmbedding, doc.Embedding)
-		if err != nil {
-			// Skip documents with incompatible embeddings
-			continue
+	if idx.vectors != nil {
+		if len(queryEmbedding) != idx.dim {
+			return nil, fmt.Errorf("vector dimensions don't match: %d vs %d", len(queryEmbedding), idx.dim)
+		}
+		q := make([]float32, len(queryEmbedding))
+		for i, v := range queryEmbedding {
+			q[i] = float32(v)
+		}
+		for i := range idx.Documents {
+			if !f.Match(idx.Documents[i].Metadata) {
+				continue
+			}
+			if score, ok := cosine32(q, idx.vectors[i*idx.dim:(i+1)*idx.dim]); ok {
+				hits = append(hits, scored{i, score})
+			}
+		}
+	} else {
+		for i, doc := range idx.Documents {
+			if !f.Match(doc.Metadata) {
+				continue
+			}
+			score, err := CosineSimilarity(queryEmbedding, doc.Embedding)
+			if err != nil {
+				// Skip documents with incompatible embeddings
+				continue
+			}
+			hits = append(hits, scored{i, score})
 		}
-		results = append(results, SearchResult{
-			Document: doc,
-			Score:    score,
-		})
 	}
 	
 	// Sort by score in descending order (highest similarity first)
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Score > results[j].Score
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].score > hits[j].score
 	})
 	
 	// Return top-k results
-	if topK > len(results) {
-		topK = len(results)
+	if topK > len(hits) {
+		topK = len(hits)
 	}
 	
-	return results[:topK], nil
-}
-
-// Save persists the index to disk
-func (idx *Index) Save(path string) error {
-	data, err := json.MarshalIndent(idx, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshal index: %w", err)
+	results := make([]SearchResult, topK)
+	for i, h := range hits[:topK] {
+		doc := idx.Documents[h.i]
+		doc.Embedding = idx.embedding(h.i)
+		results[i] = SearchResult{Document: doc, Score: h.score}
 	}
-	
-	if err := os.WriteFile(path, data, 0o644); err != nil {
-		return fmt.Errorf("write index: %w", err)
-	}
-	
-	return nil
+	return results, nil
 }
 
-// Load loads an index from disk
-func Load(path string) (*Index, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("read index: %w", err)
+// cosine32 is CosineSimilarity for float32 vectors of equal length; ok is
+// false when either vector is zero.
+func cosine32(a, b []float32) (float64, bool) {
+	var dot, normA, normB float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
 	}
-	
-	var idx Index
-	if err := json.Unmarshal(data, &idx); err != nil {
-		return nil, fmt.Errorf("unmarshal index: %w", err)
+	if normA == 0 || normB == 0 {
+		return 0, false
 	}
-	
-	return &idx, nil
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), true
 }
 
 // Count returns the number of documents in the index
@@ -0,0 +1,437 @@
+package vector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IVFConfig tunes an inverted-file index.
+type IVFConfig struct {
+	NList  int `json:"nlist,omitempty"`  // number of k-means clusters; 0 means sqrt(documents)
+	NProbe int `json:"nprobe,omitempty"` // clusters scanned per search
+}
+
+// DefaultIVFConfig returns the settings used for zero fields.
+func DefaultIVFConfig() IVFConfig {
+	return IVFConfig{NProbe: 8}
+}
+
+func (c IVFConfig) withDefaults() IVFConfig {
+	if c.NProbe <= 0 {
+		c.NProbe = DefaultIVFConfig().NProbe
+	}
+	return c
+}
+
+// ivfIterations is the number of k-means rounds run when training.
+const ivfIterations = 12
+
+// IVF is an approximate nearest neighbour index that clusters the vectors
+// with k-means and, at query time, only scans the clusters whose centroids
+// are closest to the query. Clusters are trained when the index is saved, so
+// until then searches are exact. Like HNSW it keeps its documents in an
+// Index and stores the clustering in a file next to it (see ClustersPath).
+type IVF struct {
+	cfg  IVFConfig
+	docs *Index
+
+	dim       int
+	vecs      []float32 // normalized vectors, dim per document
+	centroids []float32 // normalized centroids, dim per cluster
+	assign    []int32   // cluster of each document
+	lists     [][]int32 // documents of each cluster
+	trainedOn int       // document count when the clusters were trained
+}
+
+// NewIVF creates an empty IVF index.
+func NewIVF(cfg IVFConfig) *IVF {
+	return &IVF{cfg: cfg.withDefaults(), docs: NewIndex()}
+}
+
+// Config returns the settings in use.
+func (v *IVF) Config() IVFConfig {
+	return v.cfg
+}
+
+// Trained reports whether the clusters have been trained.
+func (v *IVF) Trained() bool {
+	return len(v.lists) > 0
+}
+
+// Count returns the number of documents in the index.
+func (v *IVF) Count() int {
+	return v.docs.Count()
+}
+
+// Add appends a document and, once trained, files it under its nearest
+// cluster.
+func (v *IVF) Add(doc Document) {
+	v.docs.Add(doc)
+	if v.dim == 0 {
+		v.dim = len(doc.Embedding)
+	}
+	v.vecs = append(v.vecs, normalize(doc.Embedding, v.dim)...)
+	if v.Trained() {
+		i := len(v.assign)
+		c := v.nearest(v.vec(i))
+		v.assign = append(v.assign, int32(c))
+		v.lists[c] = append(v.lists[c], int32(i))
+	}
+}
+
+// Delete removes the documents with the given IDs.
+func (v *IVF) Delete(ids ...string) int {
+	drop := map[int]bool{}
+	for _, id := range ids {
+		if i, ok := v.docs.position(id); ok {
+			drop[i] = true
+		}
+	}
+	if len(drop) == 0 {
+		return 0
+	}
+	vecs, assign := v.vecs[:0], v.assign[:0]
+	for i := range v.docs.Documents {
+		if drop[i] {
+			continue
+		}
+		vecs = append(vecs, v.vec(i)...)
+		if v.Trained() {
+			assign = append(assign, v.assign[i])
+		}
+	}
+	removed := v.docs.filter(func(i int) bool { return !drop[i] })
+	v.vecs, v.assign = vecs, assign
+	if v.Trained() {
+		v.rebuildLists()
+	}
+	return removed
+}
+
+// Get returns the document with the given ID.
+func (v *IVF) Get(id string) (Document, bool) {
+	return v.docs.Get(id)
+}
+
+// IDs returns the IDs of all documents in insertion order.
+func (v *IVF) IDs() []string {
+	return v.docs.IDs()
+}
+
+// SetEmbeddingModel records the model that produced the embeddings.
+func (v *IVF) SetEmbeddingModel(name string) {
+	v.docs.EmbeddingModel = name
+}
+
+// Close is a no-op; an IVF index holds no external resources.
+func (v *IVF) Close() error {
+	return nil
+}
+
+// Stats describes the index.
+func (v *IVF) Stats() Stats {
+	return Stats{
+		Backend:        BackendIVF,
+		Documents:      v.Count(),
+		Dimension:      v.dim,
+		EmbeddingModel: v.docs.EmbeddingModel,
+		Params: map[string]int{
+			"nlist":     len(v.lists),
+			"nprobe":    v.cfg.NProbe,
+			"trainedOn": v.trainedOn,
+		},
+	}
+}
+
+// Train clusters the current documents. Save calls it when the index has
+// never been trained or has more than doubled in size since.
+func (v *IVF) Train() {
+	n := len(v.docs.Documents)
+	if n == 0 || v.dim == 0 {
+		v.centroids, v.assign, v.lists, v.trainedOn = nil, nil, nil, 0
+		return
+	}
+	k := v.cfg.NList
+	if k <= 0 {
+		k = int(math.Sqrt(float64(n)))
+	}
+	k = max(1, min(k, n))
+
+	// Train on a sample so large indexes stay quick to rebuild.
+	rng := rand.New(rand.NewPCG(1, 2))
+	sample := rng.Perm(n)[:min(n, 64*k)]
+
+	// k-means++ seeding
+	v.centroids = make([]float32, 0, k*v.dim)
+	v.centroids = append(v.centroids, v.vec(sample[0])...)
+	dist := make([]float64, len(sample))
+	for c := 1; c < k; c++ {
+		var sum float64
+		for i, s := range sample {
+			_, best := v.closest(v.vec(s), c)
+			dist[i] = 1 - best
+			sum += dist[i]
+		}
+		pick := sample[rng.IntN(len(sample))]
+		if sum > 0 {
+			r := rng.Float64() * sum
+			for i, d := range dist {
+				if r -= d; r <= 0 {
+					pick = sample[i]
+					break
+				}
+			}
+		}
+		v.centroids = append(v.centroids, v.vec(pick)...)
+	}
+
+	// spherical k-means: centroids are the normalized means of their members
+	sums := make([]float64, k*v.dim)
+	for range ivfIterations {
+		clear(sums)
+		for _, s := range sample {
+			c, _ := v.closest(v.vec(s), k)
+			for j, x := range v.vec(s) {
+				sums[c*v.dim+j] += float64(x)
+			}
+		}
+		for c := range k {
+			if mean := normalize(sums[c*v.dim:(c+1)*v.dim], v.dim); !isZero(mean) {
+				copy(v.centroids[c*v.dim:], mean)
+			}
+		}
+	}
+
+	v.assign = make([]int32, n)
+	for i := range n {
+		v.assign[i] = int32(v.nearest(v.vec(i)))
+	}
+	v.lists = make([][]int32, k)
+	v.rebuildLists()
+	v.trainedOn = n
+}
+
+func (v *IVF) rebuildLists() {
+	for c := range v.lists {
+		v.lists[c] = v.lists[c][:0]
+	}
+	for i, c := range v.assign {
+		v.lists[c] = append(v.lists[c], int32(i))
+	}
+}
+
+// closest returns the most similar of the first k centroids to q.
+func (v *IVF) closest(q []float32, k int) (int, float64) {
+	best, bestSim := 0, math.Inf(-1)
+	for c := range k {
+		if s := dot32(q, v.centroids[c*v.dim:(c+1)*v.dim]); s > bestSim {
+			best, bestSim = c, s
+		}
+	}
+	return best, bestSim
+}
+
+func (v *IVF) nearest(q []float32) int {
+	c, _ := v.closest(q, len(v.centroids)/v.dim)
+	return c
+}
+
+// Search returns the approximate top-k documents by cosine similarity,
+// scanning the NProbe clusters closest to the query.
+func (v *IVF) Search(queryEmbedding []float64, topK int) ([]SearchResult, error) {
+	return v.SearchFilter(queryEmbedding, topK, nil)
+}
+
+// SearchFilter is Search over the documents whose metadata matches f. More
+// clusters are scanned when the first NProbe hold fewer than topK matches.
+func (v *IVF) SearchFilter(queryEmbedding []float64, topK int, f *Filter) ([]SearchResult, error) {
+	if len(v.docs.Documents) == 0 || topK <= 0 {
+		return []SearchResult{}, nil
+	}
+	if len(queryEmbedding) != v.dim {
+		return nil, fmt.Errorf("vector dimensions don't match: %d vs %d", len(queryEmbedding), v.dim)
+	}
+	q := normalize(queryEmbedding, v.dim)
+	if isZero(q) {
+		return nil, errors.New("cannot compute similarity: query vector is zero")
+	}
+
+	var cands []candidate
+	score := func(i int32) {
+		if !f.Match(v.docs.Documents[i].Metadata) {
+			return
+		}
+		if vec := v.vec(int(i)); !isZero(vec) {
+			cands = append(cands, candidate{id: i, sim: dot32(q, vec)})
+		}
+	}
+	if !v.Trained() {
+		for i := range v.docs.Documents {
+			score(int32(i))
+		}
+	} else {
+		probes := make([]candidate, len(v.lists))
+		for c := range v.lists {
+			probes[c] = candidate{id: int32(c), sim: dot32(q, v.centroids[c*v.dim:(c+1)*v.dim])}
+		}
+		sort.Slice(probes, func(i, j int) bool { return probes[i].sim > probes[j].sim })
+		for n, p := range probes {
+			if n >= v.cfg.NProbe && len(cands) >= topK {
+				break
+			}
+			for _, i := range v.lists[p.id] {
+				score(i)
+			}
+		}
+	}
+
+	sort.Slice(cands, func(i, j int) bool { return cands[i].sim > cands[j].sim })
+	if len(cands) > topK {
+		cands = cands[:topK]
+	}
+	results := make([]SearchResult, len(cands))
+	for i, c := range cands {
+		doc := v.docs.Documents[c.id]
+		doc.Embedding = v.docs.Embedding(int(c.id))
+		results[i] = SearchResult{Document: doc, Score: c.sim}
+	}
+	return results, nil
+}
+
+func (v *IVF) vec(i int) []float32 {
+	return v.vecs[i*v.dim : (i+1)*v.dim]
+}
+
+func dot32(a, b []float32) float64 {
+	var dot float32
+	for i, x := range a {
+		dot += x * b[i]
+	}
+	return float64(dot)
+}
+
+// Clusters file layout (little-endian): magic "OCNI", version, dim, nlist
+// uint32, trainedOn and count uint64, then nlist*dim float32 centroids and
+// count int32 cluster assignments in document order.
+const ivfMagic = "OCNI"
+
+// ClustersPath returns the clusters file that accompanies the index at path.
+func ClustersPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".ivf"
+}
+
+// Save writes the documents and vectors to path (as Index.Save does) and the
+// clusters to ClustersPath(path), training them first if needed.
+func (v *IVF) Save(path string) error {
+	n := len(v.docs.Documents)
+	stale := v.cfg.NList > 0 && v.cfg.NList != len(v.lists) && v.cfg.NList <= n
+	if !v.Trained() || n > 2*v.trainedOn || stale {
+		v.Train()
+	}
+	if err := v.docs.Save(path); err != nil {
+		return err
+	}
+	err := writeAtomic(ClustersPath(path), func(w io.Writer) error {
+		b := []byte(ivfMagic)
+		b = binary.LittleEndian.AppendUint32(b, sidecarVersion)
+		b = binary.LittleEndian.AppendUint32(b, uint32(v.dim))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(v.lists)))
+		b = binary.LittleEndian.AppendUint64(b, uint64(v.trainedOn))
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(v.assign)))
+		for _, x := range v.centroids {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(x))
+		}
+		for _, c := range v.assign {
+			b = binary.LittleEndian.AppendUint32(b, uint32(c))
+		}
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("write clusters: %w", err)
+	}
+	return nil
+}
+
+// OpenIVF loads an IVF index written by Save. When the clusters file is
+// missing (e.g. the model was built with another backend) the clusters are
+// trained from the stored vectors with cfg.
+func OpenIVF(path string, cfg IVFConfig) (*IVF, error) {
+	docs, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	v := NewIVF(cfg)
+	v.docs = docs
+	for i := range docs.Documents {
+		if v.dim == 0 {
+			v.dim = len(docs.Documents[i].Embedding)
+		}
+		v.vecs = append(v.vecs, normalize(docs.Documents[i].Embedding, v.dim)...)
+	}
+
+	f, err := os.Open(ClustersPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		v.Train()
+		return v, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read clusters: %w", err)
+	}
+	defer f.Close()
+	if err := v.readClusters(bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("read clusters: %w", err)
+	}
+	return v, nil
+}
+
+func (v *IVF) readClusters(r io.Reader) error {
+	var head [4 + 4*3 + 8*2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return err
+	}
+	if string(head[:4]) != ivfMagic {
+		return ErrFormat
+	}
+	if version := binary.LittleEndian.Uint32(head[4:]); version != sidecarVersion {
+		return fmt.Errorf("%w: clusters version %d", ErrFormat, version)
+	}
+	dim := int(binary.LittleEndian.Uint32(head[8:]))
+	k := int(binary.LittleEndian.Uint32(head[12:]))
+	trainedOn := binary.LittleEndian.Uint64(head[16:])
+	count := binary.LittleEndian.Uint64(head[24:])
+	if count != uint64(v.Count()) || (count > 0 && dim != v.dim) {
+		return fmt.Errorf("%w: clusters cover %d documents of dimension %d, index has %d of dimension %d",
+			ErrFormat, count, dim, v.Count(), v.dim)
+	}
+
+	buf := make([]byte, 4*(k*dim+int(count)))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	v.centroids = make([]float32, k*dim)
+	for i := range v.centroids {
+		v.centroids[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	buf = buf[4*k*dim:]
+	v.assign = make([]int32, count)
+	for i := range v.assign {
+		v.assign[i] = int32(binary.LittleEndian.Uint32(buf[4*i:]))
+		if v.assign[i] < 0 || int(v.assign[i]) >= k {
+			return fmt.Errorf("%w: cluster out of range", ErrFormat)
+		}
+	}
+	v.lists = make([][]int32, k)
+	v.rebuildLists()
+	v.trainedOn = int(trainedOn)
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package vector
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads f into memory on platforms without mmap support.
+func mapFile(f *os.File) ([]byte, func() error, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
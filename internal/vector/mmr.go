@@ -0,0 +1,49 @@
+package vector
+
+// MMR re-ranks candidates by Maximal Marginal Relevance and returns up to k
+// of them. Results are picked greedily, each maximising
+//
+//	lambda*relevance - (1-lambda)*max similarity to the results already picked
+//
+// where relevance is the candidate's Score and similarity is the cosine of the
+// stored embeddings. lambda 1 keeps the original ranking; lower values trade
+// relevance for diversity, pushing near-duplicates down. Scores are returned
+// unchanged. Candidates without a usable embedding count as dissimilar to
+// everything.
+func MMR(candidates []SearchResult, k int, lambda float64) []SearchResult {
+	k = min(k, len(candidates))
+	if k <= 0 {
+		return []SearchResult{}
+	}
+
+	picked := make([]SearchResult, 0, k)
+	used := make([]bool, len(candidates))
+	// maxSim[i] is candidate i's highest similarity to a picked result
+	maxSim := make([]float64, len(candidates))
+	for len(picked) < k {
+		best, bestScore := -1, 0.0
+		for i, c := range candidates {
+			if used[i] {
+				continue
+			}
+			score := lambda * c.Score
+			if len(picked) > 0 {
+				score -= (1 - lambda) * maxSim[i]
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		used[best] = true
+		picked = append(picked, candidates[best])
+		for i, c := range candidates {
+			if used[i] {
+				continue
+			}
+			if sim, err := CosineSimilarity(c.Document.Embedding, candidates[best].Document.Embedding); err == nil && sim > maxSim[i] {
+				maxSim[i] = sim
+			}
+		}
+	}
+	return picked
+}
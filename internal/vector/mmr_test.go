@@ -0,0 +1,36 @@
+package vector
+
+import "testing"
+
+func TestMMR(t *testing.T) {
+	candidates := []SearchResult{
+		{Document: Document{ID: "a", Embedding: []float64{1, 0, 0}}, Score: 0.95},
+		{Document: Document{ID: "a-copy", Embedding: []float64{1, 0.01, 0}}, Score: 0.94},
+		{Document: Document{ID: "b", Embedding: []float64{0.6, 0.8, 0}}, Score: 0.80},
+		{Document: Document{ID: "c", Embedding: []float64{0, 0, 1}}, Score: 0.30},
+	}
+	ids := func(rs []SearchResult) []string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.Document.ID)
+		}
+		return out
+	}
+
+	if got := ids(MMR(candidates, 3, 1)); got[0] != "a" || got[1] != "a-copy" || got[2] != "b" {
+		t.Fatalf("lambda 1 should keep the ranking, got %v", got)
+	}
+	got := MMR(candidates, 2, 0.7)
+	if ids(got)[0] != "a" || ids(got)[1] != "b" {
+		t.Fatalf("near-duplicate not demoted: %v", ids(got))
+	}
+	if got[1].Score != 0.80 {
+		t.Fatalf("score changed: %v", got[1].Score)
+	}
+	if got := MMR(candidates, 10, 0); len(got) != 4 {
+		t.Fatalf("expected all candidates, got %d", len(got))
+	}
+	if got := MMR(nil, 3, 0.5); len(got) != 0 {
+		t.Fatalf("expected no results, got %v", got)
+	}
+}
@@ -0,0 +1,196 @@
+package vector
+
+import (
+	"fmt"
+)
+
+// Index backends selectable through Config.Backend.
+const (
+	BackendFlat = "flat" // exact brute-force search (Index)
+	BackendHNSW = "hnsw" // approximate graph search (HNSW)
+	BackendIVF  = "ivf"  // approximate inverted-file search (IVF)
+)
+
+// Store is implemented by every index backend, so callers can build and query
+// a model's index without knowing which backend it uses. All backends persist
+// the documents and vectors in the flat binary format at the given path and
+// keep any backend-specific data in files next to it.
+type Store interface {
+	// Add appends a document. Its ID must not already be in the store.
+	Add(doc Document)
+	// Delete removes the documents with the given IDs and returns how many
+	// were found.
+	Delete(ids ...string) int
+	// Get returns the document with the given ID, including its embedding.
+	Get(id string) (Document, bool)
+	// IDs returns the IDs of all documents in insertion order.
+	IDs() []string
+	// Search returns the top-k documents by cosine similarity.
+	Search(queryEmbedding []float64, topK int) ([]SearchResult, error)
+	// SearchFilter is Search over the documents whose metadata matches f.
+	SearchFilter(queryEmbedding []float64, topK int, f *Filter) ([]SearchResult, error)
+	// Count returns the number of documents.
+	Count() int
+	// SetEmbeddingModel records the model that produced the embeddings.
+	SetEmbeddingModel(name string)
+	// Save persists the store at path.
+	Save(path string) error
+	// Close releases resources held by a store returned by OpenStore.
+	Close() error
+	// Stats describes the store.
+	Stats() Stats
+}
+
+// Stats describes a Store.
+type Stats struct {
+	Backend        string         `json:"backend"`
+	Documents      int            `json:"documents"`
+	Dimension      int            `json:"dimension"`
+	EmbeddingModel string         `json:"embeddingModel,omitempty"`
+	Params         map[string]int `json:"params,omitempty"` // backend tuning and shape
+}
+
+// Config selects and tunes an index backend. The zero value is a flat index.
+type Config struct {
+	Backend string     `json:"backend,omitempty"`
+	HNSW    HNSWConfig `json:"hnsw,omitzero"`
+	IVF     IVFConfig  `json:"ivf,omitzero"`
+}
+
+// BackendName returns the configured backend, defaulting to BackendFlat.
+func (c Config) BackendName() string {
+	if c.Backend == "" {
+		return BackendFlat
+	}
+	return c.Backend
+}
+
+// Validate reports an unknown backend.
+func (c Config) Validate() error {
+	switch c.BackendName() {
+	case BackendFlat, BackendHNSW, BackendIVF:
+		return nil
+	default:
+		return fmt.Errorf("unknown index backend %q (want %s, %s or %s)", c.Backend, BackendFlat, BackendHNSW, BackendIVF)
+	}
+}
+
+// NewStore creates an empty store for the configured backend.
+func NewStore(cfg Config) (Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.BackendName() {
+	case BackendHNSW:
+		return NewHNSW(cfg.HNSW), nil
+	case BackendIVF:
+		return NewIVF(cfg.IVF), nil
+	default:
+		return NewIndex(), nil
+	}
+}
+
+// OpenStore opens the store saved at path with the configured backend.
+func OpenStore(path string, cfg Config) (Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.BackendName() {
+	case BackendHNSW:
+		return OpenHNSW(path, cfg.HNSW)
+	case BackendIVF:
+		return OpenIVF(path, cfg.IVF)
+	default:
+		return Open(path)
+	}
+}
+
+var (
+	_ Store = (*Index)(nil)
+	_ Store = (*HNSW)(nil)
+	_ Store = (*IVF)(nil)
+)
+
+// Delete removes the documents with the given IDs.
+func (idx *Index) Delete(ids ...string) int {
+	drop := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	return idx.filter(func(i int) bool { return !drop[idx.Documents[i].ID] })
+}
+
+// filter keeps the documents for which keep returns true and returns how many
+// were removed.
+func (idx *Index) filter(keep func(i int) bool) int {
+	idx.materialize()
+	kept := idx.Documents[:0]
+	for i, doc := range idx.Documents {
+		if keep(i) {
+			kept = append(kept, doc)
+		}
+	}
+	removed := len(idx.Documents) - len(kept)
+	clear(idx.Documents[len(kept):])
+	idx.Documents = kept
+	idx.byID = nil
+	return removed
+}
+
+// Get returns the document with the given ID.
+func (idx *Index) Get(id string) (Document, bool) {
+	i, ok := idx.position(id)
+	if !ok {
+		return Document{}, false
+	}
+	doc := idx.Documents[i]
+	doc.Embedding = idx.embedding(i)
+	return doc, true
+}
+
+// position returns the position of the document with the given ID.
+func (idx *Index) position(id string) (int, bool) {
+	if idx.byID == nil {
+		idx.byID = make(map[string]int, len(idx.Documents))
+		for i, doc := range idx.Documents {
+			idx.byID[doc.ID] = i
+		}
+	}
+	i, ok := idx.byID[id]
+	return i, ok
+}
+
+// IDs returns the IDs of all documents in insertion order.
+func (idx *Index) IDs() []string {
+	ids := make([]string, len(idx.Documents))
+	for i, doc := range idx.Documents {
+		ids[i] = doc.ID
+	}
+	return ids
+}
+
+// SetEmbeddingModel records the model that produced the embeddings.
+func (idx *Index) SetEmbeddingModel(name string) {
+	idx.EmbeddingModel = name
+}
+
+// Dimension returns the embedding dimension, or 0 for an empty index.
+func (idx *Index) Dimension() int {
+	if idx.vectors != nil {
+		return idx.dim
+	}
+	if len(idx.Documents) == 0 {
+		return 0
+	}
+	return len(idx.Documents[0].Embedding)
+}
+
+// Stats describes the index.
+func (idx *Index) Stats() Stats {
+	return Stats{
+		Backend:        BackendFlat,
+		Documents:      idx.Count(),
+		Dimension:      idx.Dimension(),
+		EmbeddingModel: idx.EmbeddingModel,
+	}
+}
@@ -0,0 +1,101 @@
+package vector
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreBackends(t *testing.T) {
+	for _, backend := range []string{BackendFlat, BackendHNSW, BackendIVF} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := Config{Backend: backend}
+			s, err := NewStore(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs := randomDocs(300, 8, 7)
+			for i, d := range docs {
+				d.Metadata = Metadata{"even": i%2 == 0}
+				s.Add(d)
+			}
+			if n := s.Delete("doc0", "doc1", "missing"); n != 2 {
+				t.Fatalf("Delete removed %d, want 2", n)
+			}
+			if _, ok := s.Get("doc0"); ok {
+				t.Fatal("deleted document still returned by Get")
+			}
+			got, ok := s.Get("doc5")
+			if !ok || len(got.Embedding) != 8 {
+				t.Fatalf("Get(doc5) = %+v, %v", got, ok)
+			}
+			s.SetEmbeddingModel("m")
+
+			path := filepath.Join(t.TempDir(), "index.bin")
+			if err := s.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := OpenStore(path, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer loaded.Close()
+
+			st := loaded.Stats()
+			if st.Backend != backend || st.Documents != 298 || st.Dimension != 8 || st.EmbeddingModel != "m" {
+				t.Fatalf("unexpected stats: %+v", st)
+			}
+			if ids := loaded.IDs(); len(ids) != 298 || ids[0] != "doc2" {
+				t.Fatalf("unexpected IDs: %d starting %v", len(ids), ids[:1])
+			}
+			results, err := loaded.Search(docs[5].Embedding, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 || results[0].Document.ID != "doc5" {
+				t.Fatalf("document not found by its own embedding: %+v", results)
+			}
+			for _, r := range results {
+				if r.Document.ID == "doc0" || r.Document.ID == "doc1" {
+					t.Fatal("deleted document returned by Search")
+				}
+			}
+
+			odd, _ := ParseFilter("even=false")
+			results, err = loaded.SearchFilter(docs[4].Embedding, 10, odd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 10 {
+				t.Fatalf("filtered search returned %d results, want 10", len(results))
+			}
+			for _, r := range results {
+				if r.Document.Metadata["even"] != false {
+					t.Fatalf("filter not applied: %+v", r.Document.Metadata)
+				}
+			}
+		})
+	}
+	if _, err := NewStore(Config{Backend: "annoy"}); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+}
+
+func TestIVFRecall(t *testing.T) {
+	docs := randomDocs(2000, 32, 1)
+	flat, ivf := NewIndex(), NewIVF(IVFConfig{NProbe: 16})
+	for _, d := range docs {
+		flat.Add(d)
+		ivf.Add(d)
+	}
+	ivf.Train()
+	queries := randomDocs(50, 32, 2)
+	if r := recall(t, flat, ivf, queries, 10); r < 0.8 {
+		t.Fatalf("recall@10 = %.3f, want >= 0.8", r)
+	}
+
+	// with every cluster probed the search is exact
+	ivf.cfg.NProbe = len(ivf.lists)
+	if r := recall(t, flat, ivf, queries, 10); r != 1 {
+		t.Fatalf("full probe recall@10 = %.3f, want 1", r)
+	}
+}